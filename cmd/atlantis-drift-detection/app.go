@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/config"
+	"github.com/cresta/atlantis-drift-detection/internal/drifter"
+	"github.com/cresta/atlantis-drift-detection/internal/filter"
+	"github.com/cresta/atlantis-drift-detection/internal/metrics"
+	"github.com/cresta/atlantis-drift-detection/internal/notification"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/cresta/atlantis-drift-detection/internal/scheduler"
+	"github.com/cresta/atlantis-drift-detection/internal/server"
+	"github.com/cresta/atlantis-drift-detection/internal/terraform"
+	"github.com/cresta/gogit"
+	"github.com/cresta/gogithub"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// loadConfig reads the config file at path, or $CONFIG_FILE if path is empty, applies environment overrides and
+// validates the result
+func loadConfig(path string) (*config.Config, error) {
+	if err := loadEnvIfExists(); err != nil {
+		return nil, fmt.Errorf("failed to load .env: %w", err)
+	}
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// app holds everything the commands need, built from the configuration
+type app struct {
+	cfg      *config.Config
+	logger   *zap.Logger
+	ghClient gogithub.GitHub
+	cache    processedcache.ProcessedCache
+	atlantis *atlantis.Client
+	runner   *drifter.Multi
+	registry *prometheus.Registry
+}
+
+func newApp(ctx context.Context, logger *zap.Logger) (*app, error) {
+	cfg, err := loadConfig("")
+	if err != nil {
+		return nil, err
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	m := metrics.New(registry)
+	cloner := &gogit.Cloner{
+		Logger: &zapGogitLogger{logger},
+	}
+	var existingConfig *gogithub.NewGQLClientConfig
+	if os.Getenv("GITHUB_TOKEN") != "" {
+		existingConfig = &gogithub.NewGQLClientConfig{Token: os.Getenv("GITHUB_TOKEN")}
+	}
+	ghClient, err := gogithub.NewGQLClient(ctx, logger, existingConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create github client: %w", err)
+	}
+
+	var cache processedcache.ProcessedCache = processedcache.Noop{}
+	if cfg.Cache.DynamodbTable != "" {
+		logger.Info("setting up dynamodb result cache")
+		cache, err = processedcache.NewDynamoDB(ctx, cfg.Cache.DynamodbTable)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dynamodb result cache: %w", err)
+		}
+		// Entries from before keys included the repo can only belong to a single repo
+		if repos := cfg.ResolvedRepos(); len(repos) == 1 {
+			cache = &processedcache.LegacyKeys{ProcessedCache: cache, Repo: repos[0].Name}
+		}
+	}
+
+	atlantisClient := &atlantis.Client{
+		AtlantisHostname: cfg.Atlantis.Host,
+		Token:            cfg.Atlantis.Token,
+		HTTPClient:       http.DefaultClient,
+		Logger:           logger.With(zap.String("atlantis", "true")),
+		Metrics:          m,
+	}
+	if cfg.Atlantis.PlansPerMinute > 0 {
+		atlantisClient.RateLimit = rate.NewLimiter(rate.Limit(cfg.Atlantis.PlansPerMinute/60), cfg.Atlantis.PlanBurst)
+	}
+	if cfg.Atlantis.AdaptiveConcurrency {
+		atlantisClient.Concurrency = atlantis.NewAdaptiveConcurrency(1, max(cfg.ParallelRuns, 1))
+		m.SetAtlantisConcurrencyLimit(atlantisClient.Concurrency.Limit())
+	}
+	shard := drifter.Shard{Index: cfg.Shard.Index, Count: cfg.Shard.Count}
+	a := &app{
+		cfg:      cfg,
+		logger:   logger,
+		ghClient: ghClient,
+		cache:    cache,
+		atlantis: atlantisClient,
+		runner: &drifter.Multi{
+			Logger:     logger.With(zap.String("drifter", "true")),
+			ReportPath: cfg.ReportPath,
+			RunTimeout: cfg.Timeouts.Run,
+			Shard:      shard,
+		},
+		registry: registry,
+	}
+	for _, repo := range cfg.ResolvedRepos() {
+		repoLogger := logger.With(zap.String("repo", repo.Name))
+		notif := a.newNotification(repoLogger, repo.Notifications, m)
+		// Each repo gets its own terraform client so its logs name the repo
+		tf := terraform.Client{
+			Logger:          repoLogger.With(zap.String("terraform", "true")),
+			PluginMirrorDir: cfg.TerraformPluginMirror,
+		}
+		f, err := filter.New(repo.DirectoryWhitelist, repo.Filters.Include, repo.Filters.Exclude)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filters for %s: %w", repo.Name, err)
+		}
+		priorities, err := filter.NewPriorities(repo.Priorities)
+		if err != nil {
+			return nil, fmt.Errorf("invalid priorities for %s: %w", repo.Name, err)
+		}
+		a.runner.Drifters = append(a.runner.Drifters, &drifter.Drifter{
+			Filter:             f,
+			Priorities:         priorities,
+			Logger:             repoLogger.With(zap.String("drifter", "true")),
+			Repo:               repo.Name,
+			Ref:                repo.Ref,
+			VCSType:            repo.VCSType,
+			CloneURL:           repo.CloneURL,
+			AtlantisConfigPath: repo.AtlantisConfigPath,
+			AtlantisClient:     atlantisClient,
+			ParallelRuns:       cfg.ParallelRuns,
+			Shard:              shard,
+			ContinueOnError:    cfg.ContinueOnError,
+			ResultCache:        cache,
+			Cloner:             cloner,
+			GithubClient:       ghClient,
+			CacheValidDuration: cfg.Cache.ValidDuration,
+			ErrorCacheDuration: cfg.Cache.ErrorValidDuration,
+			Retry: drifter.Retry{
+				Attempts:       cfg.Retry.Attempts,
+				InitialBackoff: cfg.Retry.InitialBackoff,
+				MaxBackoff:     cfg.Retry.MaxBackoff,
+			},
+			ProjectTimeout:     cfg.Timeouts.Project,
+			Terraform:          &tf,
+			Notification:       notif,
+			SkipWorkspaceCheck: cfg.SkipWorkspaceCheck,
+			Metrics:            m,
+			RunLeaseTTL:        cfg.RunLockTTL,
+			RunLeaseOwner:      instanceID(),
+		})
+	}
+	return a, nil
+}
+
+// newNotification builds the notifications for one repo
+func (a *app) newNotification(logger *zap.Logger, cfg config.Notifications, m *metrics.Metrics) *notification.Multi {
+	notif := &notification.Multi{
+		Notifications: []notification.Notification{
+			&notification.Zap{Logger: logger.With(zap.String("notification", "true"))},
+		},
+		Metrics: m,
+	}
+	if slackClient := notification.NewSlackWebhook(cfg.Slack.WebhookURL, http.DefaultClient); slackClient != nil {
+		logger.Info("setting up slack webhook notification", zap.Bool("digest", cfg.Slack.Digest))
+		slackClient.Digest = cfg.Slack.Digest
+		notif.Notifications = append(notif.Notifications, slackClient)
+	}
+	if workflowClient := notification.NewWorkflow(a.ghClient, cfg.Workflow.Owner, cfg.Workflow.Repo, cfg.Workflow.ID, cfg.Workflow.Ref); workflowClient != nil {
+		logger.Info("setting up workflow notification")
+		notif.Notifications = append(notif.Notifications, workflowClient)
+	}
+	return notif
+}
+
+func (a *app) readinessChecks() []server.ReadinessCheck {
+	return []server.ReadinessCheck{
+		{
+			Name: "cache",
+			Check: func(ctx context.Context) error {
+				return processedcache.Ping(ctx, a.cache)
+			},
+		},
+		{
+			Name:  "atlantis",
+			Check: a.atlantis.Healthz,
+		},
+	}
+}
+
+func (a *app) newServer() *server.Server {
+	return &server.Server{
+		Logger:          a.logger.With(zap.String("server", "true")),
+		Runner:          a.runner,
+		ReadinessChecks: a.readinessChecks(),
+		Token:           a.cfg.Server.ControlToken,
+		Metrics:         promhttp.HandlerFor(a.registry, promhttp.HandlerOpts{}),
+	}
+}
+
+func (a *app) newScheduler() (*scheduler.Scheduler, error) {
+	location, err := a.cfg.Schedule.Location()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load drift timezone %s: %w", a.cfg.Schedule.Timezone, err)
+	}
+	return &scheduler.Scheduler{
+		Logger:          a.logger.With(zap.String("scheduler", "true")),
+		Runner:          a.runner,
+		Location:        location,
+		Schedules:       a.cfg.Schedule.Cron,
+		Groups:          a.cfg.Schedule.Groups.SchedulerGroups(),
+		TrickleInterval: a.cfg.Schedule.Trickle.Interval,
+		TrickleBatch:    a.cfg.Schedule.Trickle.Batch,
+	}, nil
+}
+
+// instanceID identifies this process to other replicas, for example when holding the run lease
+func instanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/drifter"
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+	"go.uber.org/zap"
+)
+
+// Exit codes.  exitDriftFound is only returned by the run command.
+const (
+	exitOK         = 0
+	exitFailed     = 1
+	exitDriftFound = 2
+)
+
+func serveCommand(ctx context.Context, logger *zap.Logger) int {
+	a, err := newApp(ctx, logger)
+	if err != nil {
+		logger.Error("Failed to set up drift detection", zap.Error(err))
+		return exitFailed
+	}
+	s, err := a.newScheduler()
+	if err != nil {
+		logger.Error("Failed to set up scheduler", zap.Error(err))
+		return exitFailed
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := s.Start(ctx); err != nil {
+		logger.Error("Failed to schedule drift detection", zap.Error(err))
+		return exitFailed
+	}
+	logger.Info("Cron scheduler started")
+
+	code := exitOK
+	// Stays nil, and so never ready, if there is no http server
+	var serverDone chan error
+	if a.cfg.Server.ListenAddress != "" {
+		if a.cfg.Server.ControlToken == "" {
+			logger.Warn("CONTROL_TOKEN is not set, so runs cannot be triggered over HTTP")
+		}
+		serverDone = make(chan error, 1)
+		go func() {
+			serverDone <- a.newServer().ListenAndServe(ctx, a.cfg.Server.ListenAddress)
+		}()
+	}
+
+	// The startup run can take hours, so it runs alongside the server to keep health checks answered
+	startupDone := make(chan struct{})
+	go func() {
+		defer close(startupDone)
+		if a.cfg.Schedule.RunOnStartup {
+			runOnStartup(ctx, logger, a.runner)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err := <-serverDone:
+		logger.Error("HTTP server failed", zap.Error(err))
+		code = exitFailed
+		serverDone = nil
+	}
+
+	logger.Info("Shutting down")
+	// Cancelling the context aborts in-flight runs, which then clean up their checkouts
+	cancel()
+	timeout := time.After(a.cfg.ShutdownTimeout)
+	select {
+	case <-s.Stop().Done():
+	case <-timeout:
+		logger.Warn("Timed out waiting for scheduled runs to stop")
+	}
+	select {
+	case <-startupDone:
+	case <-timeout:
+		logger.Warn("Timed out waiting for the startup run to stop")
+	}
+	if serverDone != nil {
+		select {
+		case <-serverDone:
+		case <-timeout:
+			logger.Warn("Timed out waiting for the http server to stop")
+		}
+	}
+	return code
+}
+
+func runOnStartup(ctx context.Context, logger *zap.Logger, runner drifter.Runner) {
+	logger.Info("Running drift detection on startup")
+	if _, err := runner.Run(ctx, drifter.RunOptions{}); errors.Is(err, drifter.ErrRunLocked) {
+		logger.Info("Skipping startup drift detection, another instance is running it")
+	} else if err != nil {
+		logger.Error("Startup drift detection failed", zap.Error(err))
+	} else {
+		logger.Info("Startup drift detection completed successfully")
+	}
+}
+
+func runCommand(ctx context.Context, logger *zap.Logger, args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "List the projects that would be planned, without calling Atlantis")
+	format := flags.String("format", "table", "Output format of a dry run: table or json")
+	if err := flags.Parse(args); err != nil {
+		return exitFailed
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected table or json\n", *format)
+		return exitFailed
+	}
+	a, err := newApp(ctx, logger)
+	if err != nil {
+		logger.Error("Failed to set up drift detection", zap.Error(err))
+		return exitFailed
+	}
+	rep, err := a.runner.Run(ctx, drifter.RunOptions{DryRun: *dryRun})
+	if errors.Is(err, drifter.ErrRunLocked) {
+		logger.Info("Skipping drift detection, another instance is running it")
+		return exitOK
+	}
+	if err != nil {
+		logger.Error("Drift detection failed", zap.Error(err))
+		return exitFailed
+	}
+	if *dryRun {
+		if err := printPlan(os.Stdout, rep, *format); err != nil {
+			logger.Error("Failed to print plan", zap.Error(err))
+			return exitFailed
+		}
+		return exitOK
+	}
+	logger.Info("Drift detection completed",
+		zap.Int("clean", rep.Count(report.OutcomeClean)),
+		zap.Int("drifted", rep.Count(report.OutcomeDrifted)),
+		zap.Int("locked", rep.Count(report.OutcomeLocked)),
+		zap.Int("temporary_errors", rep.Count(report.OutcomeTemporaryError)),
+		zap.Int("failed", rep.Count(report.OutcomeFailed)))
+	if rep.HasDrift() {
+		return exitDriftFound
+	}
+	return exitOK
+}
+
+// printPlan writes the projects a dry run would plan, and why the others are skipped
+func printPlan(w io.Writer, rep *report.Report, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep.SortedProjects())
+	}
+	return rep.WriteTable(w)
+}
+
+func checkCommand(ctx context.Context, logger *zap.Logger) int {
+	a, err := newApp(ctx, logger)
+	if err != nil {
+		logger.Error("Configuration check failed", zap.Error(err))
+		return exitFailed
+	}
+	if _, err := a.newScheduler(); err != nil {
+		logger.Error("Configuration check failed", zap.Error(err))
+		return exitFailed
+	}
+	if _, err := a.ghClient.GetAccessToken(ctx); err != nil {
+		logger.Error("Configuration check failed: unable to get a github access token", zap.Error(err))
+		return exitFailed
+	}
+	for _, c := range a.readinessChecks() {
+		if err := c.Check(ctx); err != nil {
+			logger.Error("Configuration check failed", zap.String("check", c.Name), zap.Error(err))
+			return exitFailed
+		}
+	}
+	logger.Info("Configuration check passed")
+	return exitOK
+}
+
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "validate" || len(args) > 2 {
+		fmt.Fprintf(os.Stderr, "usage: atlantis-drift-detection config validate [file]\n")
+		return exitFailed
+	}
+	path := ""
+	if len(args) == 2 {
+		path = args[1]
+	}
+	if _, err := loadConfig(path); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return exitFailed
+	}
+	fmt.Println("Configuration is valid")
+	return exitOK
+}
@@ -3,46 +3,20 @@ package main
 import (
 	"context"
 	"fmt"
-	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
-	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
-	"github.com/cresta/atlantis-drift-detection/internal/drifter"
-	"github.com/cresta/atlantis-drift-detection/internal/notification"
-	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
-	"github.com/cresta/atlantis-drift-detection/internal/terraform"
 	"github.com/cresta/gogit"
-	"github.com/cresta/gogithub"
 	"github.com/joho/godotenv"
-	"github.com/robfig/cron/v3"
 
 	// Empty import allows pinning to version atlantis uses
 	_ "time/tzdata" // Embed timezone data for containerized environments
 
-	"github.com/joeshaw/envdecode"
 	_ "github.com/nlopes/slack"
 	"go.uber.org/zap"
 )
 
-type config struct {
-	Repo                        string        `env:"REPO,required"`
-	AtlantisHostname            string        `env:"ATLANTIS_HOST,required"`
-	AtlantisToken               string        `env:"ATLANTIS_TOKEN,required"`
-	AtlantisConfigPath          string        `env:"ATLANTIS_CONFIG_PATH,default=atlantis.yaml"`
-	DirectoryWhitelist          []string      `env:"DIRECTORY_WHITELIST"`
-	SlackWebhookURL             string        `env:"SLACK_WEBHOOK_URL"`
-	SkipWorkspaceCheck          bool          `env:"SKIP_WORKSPACE_CHECK"`
-	ParallelRuns                int           `env:"PARALLEL_RUNS"`
-	DynamodbTable               string        `env:"DYNAMODB_TABLE"`
-	CacheValidDuration          time.Duration `env:"CACHE_VALID_DURATION,default=24h"`
-	WorkflowOwner               string        `env:"WORKFLOW_OWNER"`
-	WorkflowRepo                string        `env:"WORKFLOW_REPO"`
-	WorkflowId                  string        `env:"WORKFLOW_ID"`
-	WorkflowRef                 string        `env:"WORKFLOW_REF"`
-	RunOnceImmediatelyOnStartup bool          `env:"RUN_ONCE_IMMEDIATELY_ON_STARTUP"`
-}
-
 func loadEnvIfExists() error {
 	_, err := os.Stat(".env")
 	if err != nil {
@@ -79,123 +53,68 @@ func (z *zapGogitLogger) Info(_ context.Context, msg string, strings map[string]
 
 var _ gogit.Logger = (*zapGogitLogger)(nil)
 
-func main() {
-	ctx := context.Background()
+func newLogger() (*zap.Logger, error) {
 	zapCfg := zap.NewProductionConfig()
 
 	// Respect LOG_LEVEL environment variable, default to info level
 	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
 		var level zap.AtomicLevel
 		if err := level.UnmarshalText([]byte(logLevel)); err != nil {
-			panic(fmt.Errorf("invalid LOG_LEVEL: %s", logLevel))
+			return nil, fmt.Errorf("invalid LOG_LEVEL: %s", logLevel)
 		}
 		zapCfg.Level = level
 	} else {
 		zapCfg.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
 	}
 
-	logger, err := zapCfg.Build(zap.AddCaller())
-	if err != nil {
-		panic(err)
-	}
-	if err := loadEnvIfExists(); err != nil {
-		logger.Panic("Failed to load .env", zap.Error(err))
-	}
-	var cfg config
-	if err := envdecode.Decode(&cfg); err != nil {
-		logger.Panic("failed to decode config", zap.Error(err))
-	}
-	cloner := &gogit.Cloner{
-		Logger: &zapGogitLogger{logger},
-	}
-	notif := &notification.Multi{
-		Notifications: []notification.Notification{
-			&notification.Zap{Logger: logger.With(zap.String("notification", "true"))},
-		},
-	}
-	if slackClient := notification.NewSlackWebhook(cfg.SlackWebhookURL, http.DefaultClient); slackClient != nil {
-		logger.Info("setting up slack webhook notification")
-		notif.Notifications = append(notif.Notifications, slackClient)
-	}
-	var existingConfig *gogithub.NewGQLClientConfig
-	if os.Getenv("GITHUB_TOKEN") != "" {
-		existingConfig = &gogithub.NewGQLClientConfig{Token: os.Getenv("GITHUB_TOKEN")}
-	}
-	ghClient, err := gogithub.NewGQLClient(ctx, logger, existingConfig)
-	if err != nil {
-		logger.Panic("failed to create github client", zap.Error(err))
-	}
-	if workflowClient := notification.NewWorkflow(ghClient, cfg.WorkflowOwner, cfg.WorkflowRepo, cfg.WorkflowId, cfg.WorkflowRef); workflowClient != nil {
-		logger.Info("setting up workflow notification")
-		notif.Notifications = append(notif.Notifications, workflowClient)
-	}
-	tf := terraform.Client{
-		Logger: logger.With(zap.String("terraform", "true")),
-	}
-
-	var cache processedcache.ProcessedCache = processedcache.Noop{}
-	if cfg.DynamodbTable != "" {
-		logger.Info("setting up dynamodb result cache")
-		cache, err = processedcache.NewDynamoDB(ctx, cfg.DynamodbTable)
-		if err != nil {
-			logger.Panic("failed to create dynamodb result cache", zap.Error(err))
-		}
-	}
+	return zapCfg.Build(zap.AddCaller())
+}
 
-	d := drifter.Drifter{
-		DirectoryWhitelist: cfg.DirectoryWhitelist,
-		Logger:             logger.With(zap.String("drifter", "true")),
-		Repo:               cfg.Repo,
-		AtlantisConfigPath: cfg.AtlantisConfigPath,
-		AtlantisClient: &atlantis.Client{
-			AtlantisHostname: cfg.AtlantisHostname,
-			Token:            cfg.AtlantisToken,
-			HTTPClient:       http.DefaultClient,
-			Logger:           logger.With(zap.String("atlantis", "true")),
-		},
-		ParallelRuns:       cfg.ParallelRuns,
-		ResultCache:        cache,
-		Cloner:             cloner,
-		GithubClient:       ghClient,
-		CacheValidDuration: cfg.CacheValidDuration,
-		Terraform:          &tf,
-		Notification:       notif,
-		SkipWorkspaceCheck: cfg.SkipWorkspaceCheck,
-	}
+const usage = `Usage: atlantis-drift-detection [command]
 
-	if cfg.RunOnceImmediatelyOnStartup {
-		logger.Info("Running drift detection on startup")
-		if err := d.Drift(ctx); err != nil {
-			logger.Error("Startup drift detection failed", zap.Error(err))
-		} else {
-			logger.Info("Startup drift detection completed successfully")
-		}
-	}
+Commands:
+  serve                   Run drift detection on a schedule (default)
+  run                     Run drift detection once, then exit 0 if there was no drift, 2 if drift was found or 1 if the run failed
+    --dry-run             List the projects that would be planned, and why others are skipped, without calling Atlantis
+    --format table|json   Output format of --dry-run (default table)
+  check                   Verify the configuration and connectivity, then exit
+  config validate [file]  Validate the configuration file and environment, without connecting to anything
 
-	logger.Info("Starting drift detection scheduler - will run at 9am ET daily")
+The configuration file is read from $CONFIG_FILE, or drift-detection.yaml if it exists.  Environment variables override it.
+`
 
-	et, err := time.LoadLocation("America/New_York")
+func main() {
+	logger, err := newLogger()
 	if err != nil {
-		logger.Error("Failed to load Eastern Time zone, using local time", zap.Error(err))
-		et = time.Local
+		panic(err)
 	}
+	// Cancel the run context on SIGTERM (e.g. pod eviction) or Ctrl-C, so in-flight runs stop and clean up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := dispatch(ctx, logger, os.Args[1:])
+	stop()
+	_ = logger.Sync()
+	os.Exit(code)
+}
 
-	c := cron.New(cron.WithLocation(et))
-
-	_, err = c.AddFunc("0 9 * * *", func() {
-		logger.Info("Running scheduled drift detection")
-		if err := d.Drift(ctx); err != nil {
-			logger.Error("Drift detection failed", zap.Error(err))
-		} else {
-			logger.Info("Drift detection completed successfully")
-		}
-	})
-	if err != nil {
-		logger.Panic("Failed to schedule drift detection", zap.Error(err))
+func dispatch(ctx context.Context, logger *zap.Logger, args []string) int {
+	command := "serve"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "serve":
+		return serveCommand(ctx, logger)
+	case "run":
+		return runCommand(ctx, logger, args[1:])
+	case "check":
+		return checkCommand(ctx, logger)
+	case "config":
+		return configCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		return exitFailed
 	}
-
-	c.Start()
-	logger.Info("Cron scheduler started")
-
-	select {}
 }
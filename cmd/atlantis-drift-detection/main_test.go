@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestDispatch_Help(t *testing.T) {
+	require.Equal(t, exitOK, dispatch(context.Background(), zaptest.NewLogger(t), []string{"help"}))
+}
+
+func TestDispatch_UnknownCommand(t *testing.T) {
+	require.Equal(t, exitFailed, dispatch(context.Background(), zaptest.NewLogger(t), []string{"nope"}))
+}
+
+func TestDispatch_ConfigValidate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "drift-detection.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("repo: company/terraform\natlantis:\n  host: https://atlantis.example.com\n  token: token\n"), 0o600))
+	require.Equal(t, exitOK, dispatch(context.Background(), zaptest.NewLogger(t), []string{"config", "validate", path}))
+
+	require.NoError(t, os.WriteFile(path, []byte("repo: company/terraform\n"), 0o600))
+	require.Equal(t, exitFailed, dispatch(context.Background(), zaptest.NewLogger(t), []string{"config", "validate", path}))
+}
+
+func TestDispatch_RunInvalidFormat(t *testing.T) {
+	require.Equal(t, exitFailed, dispatch(context.Background(), zaptest.NewLogger(t), []string{"run", "--dry-run", "--format", "yaml"}))
+}
@@ -4,14 +4,21 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/cresta/atlantis-drift-detection/internal/metrics"
 	"github.com/runatlantis/atlantis/server/controllers"
 	"github.com/runatlantis/atlantis/server/events/command"
+	"github.com/runatlantis/atlantis/server/events/models"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
 type Client struct {
@@ -19,6 +26,20 @@ type Client struct {
 	Token            string
 	HTTPClient       *http.Client
 	Logger           *zap.Logger
+	Metrics          *metrics.Metrics
+	// RateLimit, if set, limits how often plans are requested
+	RateLimit *rate.Limiter
+	// Concurrency, if set, limits how many plans are requested at once, backing off while Atlantis is overloaded
+	Concurrency *AdaptiveConcurrency
+}
+
+// VCSGithub is the VCS type of repos hosted on GitHub
+const VCSGithub = "Github"
+
+// ValidateVCSType checks that t is a VCS type Atlantis accepts in API requests, such as "Github" or "Gitlab"
+func ValidateVCSType(t string) error {
+	_, err := models.NewVCSHostType(t)
+	return err
 }
 
 type PlanSummaryRequest struct {
@@ -27,6 +48,8 @@ type PlanSummaryRequest struct {
 	Type      string
 	Dir       string
 	Workspace string
+	// Project, if set, plans the project with this name instead of every project in Dir and Workspace
+	Project string
 }
 
 type PlanResult struct {
@@ -51,6 +74,40 @@ func (p *PlanResult) HasChanges() bool {
 	return false
 }
 
+// PlanCounts is how many resources a plan would change
+type PlanCounts struct {
+	Import  int `json:"import"`
+	Add     int `json:"add"`
+	Change  int `json:"change"`
+	Destroy int `json:"destroy"`
+}
+
+var planCountsRegex = regexp.MustCompile(`Plan: (?:(\d+) to import, )?(\d+) to add, (\d+) to change, (\d+) to destroy.`)
+
+// Counts adds up the resource changes of every summary that is not locked
+func (p *PlanResult) Counts() PlanCounts {
+	var ret PlanCounts
+	for _, summary := range p.Summaries {
+		if summary.HasLock {
+			continue
+		}
+		m := planCountsRegex.FindStringSubmatch(summary.Summary)
+		if m == nil {
+			continue
+		}
+		// Only numbers can match, and an empty optional group is zero
+		atoi := func(s string) int {
+			n, _ := strconv.Atoi(s)
+			return n
+		}
+		ret.Import += atoi(m[1])
+		ret.Add += atoi(m[2])
+		ret.Change += atoi(m[3])
+		ret.Destroy += atoi(m[4])
+	}
+	return ret
+}
+
 func (p *PlanResult) IsLocked() bool {
 	for _, summary := range p.Summaries {
 		if !summary.HasLock {
@@ -77,12 +134,79 @@ func (p *possiblyTemporaryError) Temporary() bool {
 	return true
 }
 
+// Healthz checks that the Atlantis server is reachable and reports itself healthy
+func (c *Client) Healthz(ctx context.Context) error {
+	destination := fmt.Sprintf("%s/healthz", c.AtlantisHostname)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, destination, nil)
+	if err != nil {
+		return fmt.Errorf("error parsing destination: %w", err)
+	}
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("error making health request to %s: %w", destination, err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		return fmt.Errorf("unable to close response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unhealthy response from %s: %d", destination, resp.StatusCode)
+	}
+	return nil
+}
+
+// PlanSummary plans the request, after waiting for the rate limit and a free concurrency slot
 func (c *Client) PlanSummary(ctx context.Context, req *PlanSummaryRequest) (*PlanResult, error) {
+	if c.Concurrency != nil {
+		epoch, err := c.Concurrency.acquire(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed waiting to plan: %w", err)
+		}
+		var status int
+		pr, err := c.waitAndPlan(ctx, req, &status)
+		c.Concurrency.release(epoch, overloaded(status, err))
+		c.Metrics.SetAtlantisConcurrencyLimit(c.Concurrency.Limit())
+		return pr, err
+	}
+	var status int
+	return c.waitAndPlan(ctx, req, &status)
+}
+
+// overloaded is true if a plan failed in a way that suggests Atlantis, or something in front of it, is too busy
+func overloaded(status int, err error) bool {
+	if err == nil {
+		return false
+	}
+	var tmp *possiblyTemporaryError
+	if errors.As(err, &tmp) {
+		return true
+	}
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (c *Client) waitAndPlan(ctx context.Context, req *PlanSummaryRequest, status *int) (*PlanResult, error) {
+	if c.RateLimit != nil {
+		if err := c.RateLimit.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("failed waiting for the plan rate limit: %w", err)
+		}
+	}
+	return c.planSummary(ctx, req, status)
+}
+
+// planSummary sets status to the HTTP status code of the response, if there is one
+func (c *Client) planSummary(ctx context.Context, req *PlanSummaryRequest, status *int) (*PlanResult, error) {
 	planBody := controllers.APIRequest{
 		Repository: req.Repo,
 		Ref:        req.Ref,
 		Type:       req.Type,
-		Paths: []struct {
+	}
+	if req.Project != "" {
+		planBody.Projects = []string{req.Project}
+	} else {
+		planBody.Paths = []struct {
 			Directory string
 			Workspace string
 		}{
@@ -90,7 +214,7 @@ func (c *Client) PlanSummary(ctx context.Context, req *PlanSummaryRequest) (*Pla
 				Directory: req.Dir,
 				Workspace: req.Workspace,
 			},
-		},
+		}
 	}
 	planBodyJSON, err := json.Marshal(planBody)
 	if err != nil {
@@ -104,10 +228,14 @@ func (c *Client) PlanSummary(ctx context.Context, req *PlanSummaryRequest) (*Pla
 	httpReq.Header.Set("X-Atlantis-Token", c.Token)
 	httpReq = httpReq.WithContext(ctx)
 
+	start := time.Now()
 	resp, err := c.HTTPClient.Do(httpReq)
 	if err != nil {
+		c.Metrics.ObserveAtlantisPlan(0, time.Since(start))
 		return nil, fmt.Errorf("error making plan request to %s: %w", destination, err)
 	}
+	*status = resp.StatusCode
+	c.Metrics.ObserveAtlantisPlan(resp.StatusCode, time.Since(start))
 	var fullBody bytes.Buffer
 	if _, err := io.Copy(&fullBody, resp.Body); err != nil {
 		return nil, fmt.Errorf("unable to read response body: %w", err)
@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/cresta/atlantis-drift-detection/internal/testhelper"
+	"github.com/runatlantis/atlantis/server/controllers"
 	"github.com/stretchr/testify/require"
 )
 
@@ -64,3 +66,53 @@ func TestClient_PlanSummaryChanges(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, ok.HasChanges())
 }
+
+func TestClient_Healthz(t *testing.T) {
+	healthy := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/healthz", r.URL.Path)
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	c := Client{
+		AtlantisHostname: srv.URL,
+		HTTPClient:       srv.Client(),
+	}
+	require.NoError(t, c.Healthz(context.Background()))
+	healthy = false
+	require.Error(t, c.Healthz(context.Background()))
+}
+
+func TestPlanResult_Counts(t *testing.T) {
+	p := &PlanResult{Summaries: []PlanSummary{
+		{Summary: "Plan: 1 to add, 2 to change, 0 to destroy."},
+		{Summary: "Plan: 3 to import, 0 to add, 1 to change, 4 to destroy."},
+		{Summary: "No changes. Your infrastructure matches the configuration."},
+		{HasLock: true},
+	}}
+	require.Equal(t, PlanCounts{Import: 3, Add: 1, Change: 3, Destroy: 4}, p.Counts())
+}
+
+func TestClient_PlanSummaryByProjectName(t *testing.T) {
+	var got controllers.APIRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		_, _ = w.Write([]byte(`{"ProjectResults": []}`))
+	}))
+	defer srv.Close()
+	c := Client{
+		AtlantisHostname: srv.URL,
+		HTTPClient:       srv.Client(),
+	}
+	_, err := c.PlanSummary(context.Background(), &PlanSummaryRequest{Repo: "company/terraform", Ref: "master", Type: "Github", Dir: "infra", Workspace: "default", Project: "infra-prod"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"infra-prod"}, got.Projects)
+	require.Empty(t, got.Paths)
+
+	_, err = c.PlanSummary(context.Background(), &PlanSummaryRequest{Repo: "company/terraform", Ref: "master", Type: "Github", Dir: "infra", Workspace: "default"})
+	require.NoError(t, err)
+	require.Empty(t, got.Projects)
+	require.Equal(t, "infra", got.Paths[0].Directory)
+}
@@ -0,0 +1,81 @@
+package atlantis
+
+import (
+	"context"
+	"sync"
+)
+
+// AdaptiveConcurrency limits how many plans are requested from Atlantis at once.  The limit halves when Atlantis
+// answers with errors that suggest it is overloaded, and grows back by about one for every limit's worth of plans that
+// succeed (additive increase, multiplicative decrease).  This keeps drift checks from starving plans for pull requests.
+type AdaptiveConcurrency struct {
+	min, max float64
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	// epoch counts decreases.  Only plans started since the last decrease can cause another one, so a burst of
+	// failures from requests that were already in flight halves the limit once.
+	epoch uint64
+	// freed is closed and replaced whenever a slot may have become free
+	freed chan struct{}
+}
+
+// NewAdaptiveConcurrency starts with max plans at once, and never goes below min
+func NewAdaptiveConcurrency(min int, max int) *AdaptiveConcurrency {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &AdaptiveConcurrency{
+		min:   float64(min),
+		max:   float64(max),
+		limit: float64(max),
+		freed: make(chan struct{}),
+	}
+}
+
+// Limit is how many plans may currently run at once
+func (a *AdaptiveConcurrency) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int(a.limit)
+}
+
+// acquire waits for a free slot.  The returned epoch is passed back to release.
+func (a *AdaptiveConcurrency) acquire(ctx context.Context) (uint64, error) {
+	for {
+		a.mu.Lock()
+		if a.inFlight < int(a.limit) {
+			a.inFlight++
+			epoch := a.epoch
+			a.mu.Unlock()
+			return epoch, nil
+		}
+		freed := a.freed
+		a.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+// release frees a slot, adjusting the limit by whether the plan found Atlantis overloaded
+func (a *AdaptiveConcurrency) release(epoch uint64, overloaded bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inFlight--
+	switch {
+	case overloaded && epoch == a.epoch:
+		a.limit = max(a.min, float64(int(a.limit/2)))
+		a.epoch++
+	case !overloaded:
+		a.limit = min(a.max, a.limit+1/a.limit)
+	}
+	close(a.freed)
+	a.freed = make(chan struct{})
+}
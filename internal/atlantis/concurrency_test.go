@@ -0,0 +1,91 @@
+package atlantis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	a := NewAdaptiveConcurrency(1, 8)
+	require.Equal(t, 8, a.Limit())
+
+	// Failures from plans started before a decrease only count once
+	var epochs []uint64
+	for i := 0; i < 3; i++ {
+		epoch, err := a.acquire(context.Background())
+		require.NoError(t, err)
+		epochs = append(epochs, epoch)
+	}
+	for _, epoch := range epochs {
+		a.release(epoch, true)
+	}
+	require.Equal(t, 4, a.Limit())
+
+	for i := 0; i < 5; i++ {
+		epoch, err := a.acquire(context.Background())
+		require.NoError(t, err)
+		a.release(epoch, true)
+	}
+	require.Equal(t, 1, a.Limit())
+
+	// Successes ramp the limit back up, one for every limit's worth of plans
+	for i := 0; i < 20; i++ {
+		epoch, err := a.acquire(context.Background())
+		require.NoError(t, err)
+		a.release(epoch, false)
+	}
+	require.Equal(t, 6, a.Limit())
+	for i := 0; i < 100; i++ {
+		epoch, err := a.acquire(context.Background())
+		require.NoError(t, err)
+		a.release(epoch, false)
+	}
+	require.Equal(t, 8, a.Limit())
+}
+
+func TestAdaptiveConcurrency_AcquireWaits(t *testing.T) {
+	a := NewAdaptiveConcurrency(1, 1)
+	epoch, err := a.acquire(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = a.acquire(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	acquired := make(chan error)
+	go func() {
+		_, err := a.acquire(context.Background())
+		acquired <- err
+	}()
+	a.release(epoch, false)
+	require.NoError(t, <-acquired)
+}
+
+func TestClient_PlanSummaryOverloaded(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html>bad gateway</html>", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	c := &Client{
+		AtlantisHostname: srv.URL,
+		HTTPClient:       srv.Client(),
+		RateLimit:        rate.NewLimiter(rate.Every(time.Hour), 1),
+		Concurrency:      NewAdaptiveConcurrency(1, 4),
+	}
+	_, err := c.PlanSummary(context.Background(), &PlanSummaryRequest{Repo: "company/terraform", Ref: "main", Type: VCSGithub, Dir: "infra", Workspace: "default"})
+	require.Error(t, err)
+	require.Equal(t, 2, c.Concurrency.Limit())
+
+	// The burst is used up, so the next plan waits for the rate limit
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = c.PlanSummary(ctx, &PlanSummaryRequest{Repo: "company/terraform", Ref: "main", Type: VCSGithub, Dir: "infra", Workspace: "default"})
+	require.ErrorContains(t, err, "rate limit")
+}
@@ -7,7 +7,6 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/runatlantis/atlantis/server/core/config/valid"
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,6 +21,15 @@ func (d DirectoriesWithWorkspaces) SortedKeys() []string {
 	return keys
 }
 
+// Count returns the number of directory/workspace pairs
+func (d DirectoriesWithWorkspaces) Count() int {
+	count := 0
+	for _, workspaces := range d {
+		count += len(workspaces)
+	}
+	return count
+}
+
 func ConfigToWorkspaces(cfg *SimpleAtlantisConfig) DirectoriesWithWorkspaces {
 	workspaces := make(DirectoriesWithWorkspaces)
 	for _, p := range cfg.Projects {
@@ -33,9 +41,77 @@ func ConfigToWorkspaces(cfg *SimpleAtlantisConfig) DirectoriesWithWorkspaces {
 	return workspaces
 }
 
+// Project is a single project from the Atlantis repo config.  Several projects can share a directory and workspace,
+// so Name is what tells them apart when it is set.
+type Project struct {
+	Name      string `yaml:"name"`
+	Dir       string `yaml:"dir"`
+	Workspace string `yaml:"workspace"`
+	Workflow  string `yaml:"workflow"`
+}
+
+// String is the name of the project, or dir#workspace for an unnamed project
+func (p Project) String() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.Dir + "#" + p.Workspace
+}
+
+type Projects []Project
+
+// Count returns the number of projects
+func (p Projects) Count() int {
+	return len(p)
+}
+
+// ByDirectory groups the projects by directory, keeping the order they were configured in
+func (p Projects) ByDirectory() DirectoriesWithProjects {
+	ret := make(DirectoriesWithProjects)
+	for _, project := range p {
+		ret[project.Dir] = append(ret[project.Dir], project)
+	}
+	return ret
+}
+
+// Workspaces lists the workspaces used in each directory, in the order the projects were configured
+func (p Projects) Workspaces() DirectoriesWithWorkspaces {
+	ret := make(DirectoriesWithWorkspaces)
+	for _, project := range p {
+		if !containsString(ret[project.Dir], project.Workspace) {
+			ret[project.Dir] = append(ret[project.Dir], project.Workspace)
+		}
+	}
+	return ret
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+type DirectoriesWithProjects map[string][]Project
+
+func (d DirectoriesWithProjects) SortedKeys() []string {
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func ConfigToProjects(cfg *SimpleAtlantisConfig) Projects {
+	return append(Projects(nil), cfg.Projects...)
+}
+
 type SimpleAtlantisConfig struct {
 	Version  int
-	Projects []valid.Project
+	Projects []Project
 }
 
 func ParseRepoConfig(body string) (*SimpleAtlantisConfig, error) {
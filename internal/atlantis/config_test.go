@@ -70,3 +70,21 @@ func TestParseRepoConfigFromDir(t *testing.T) {
 	require.Equal(t, 3, len(cfg.Projects))
 	require.Equal(t, "environments/aws/example", cfg.Projects[0].Dir)
 }
+
+func TestConfigToProjects(t *testing.T) {
+	cfg, err := ParseRepoConfig(exampleFromGithubIssue + `
+- name: pepe-ue2-lab-cloudtrail-audit
+  workspace: pepe-ue2-lab
+  dir: components/terraform/cloudtrail
+`)
+	require.NoError(t, err)
+	projects := ConfigToProjects(cfg)
+	require.Equal(t, Projects{
+		{Name: "pepe-ue2-lab-cloudtrail", Dir: "components/terraform/cloudtrail", Workspace: "pepe-ue2-lab", Workflow: "workflow-1"},
+		{Name: "pepe-ue2-lab-cloudtrail-audit", Dir: "components/terraform/cloudtrail", Workspace: "pepe-ue2-lab"},
+	}, projects)
+	require.Equal(t, []string{"components/terraform/cloudtrail"}, projects.ByDirectory().SortedKeys())
+	require.Equal(t, DirectoriesWithWorkspaces{"components/terraform/cloudtrail": {"pepe-ue2-lab"}}, projects.Workspaces())
+	require.Equal(t, "pepe-ue2-lab-cloudtrail", projects[0].String())
+	require.Equal(t, "infra#default", Project{Dir: "infra", Workspace: "default"}.String())
+}
@@ -1,22 +1,65 @@
 package atlantisgithub
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"os"
+	"strings"
+
 	"github.com/cresta/gogit"
 	"github.com/cresta/gogithub"
+	"github.com/cresta/pipe"
 )
 
-func CheckOutTerraformRepo(ctx context.Context, gitHubClient gogithub.GitHub, cloner *gogit.Cloner, repo string) (*gogit.Repository, error) {
+// CheckOutTerraformRepo clones a GitHub repo and checks out ref.  An empty ref leaves the default branch checked out.
+func CheckOutTerraformRepo(ctx context.Context, gitHubClient gogithub.GitHub, cloner *gogit.Cloner, repo string, ref string) (*gogit.Repository, error) {
 	token, err := gitHubClient.GetAccessToken(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
 	// https://docs.github.com/en/developers/apps/building-github-apps/authenticating-with-github-apps#http-based-git-access-by-an-installation
 	githubRepoURL := fmt.Sprintf("https://x-access-token:%s@github.com/%s.git", token, repo)
-	repository, err := cloner.Clone(ctx, githubRepoURL)
+	return CheckOutRepo(ctx, cloner, githubRepoURL, ref)
+}
+
+// CheckOutRepo clones any git URL and checks out ref.  An empty ref leaves the default branch checked out.
+func CheckOutRepo(ctx context.Context, cloner *gogit.Cloner, cloneURL string, ref string) (*gogit.Repository, error) {
+	into, err := os.MkdirTemp(cloner.TempDir, "gogit")
 	if err != nil {
+		return nil, fmt.Errorf("unable to create temporary directory: %w", err)
+	}
+	repository, err := cloner.CloneInto(ctx, cloneURL, into)
+	if err != nil {
+		// A clone interrupted part way (for example during shutdown) would otherwise leave files behind
+		_ = os.RemoveAll(into)
 		return nil, fmt.Errorf("failed to clone repo: %w", err)
 	}
+	if ref == "" {
+		return repository, nil
+	}
+	// A branch name checks out a local branch tracking origin's, and tags and commits are checked out detached
+	var stdout, stderr bytes.Buffer
+	if err := pipe.NewPiped("git", "checkout", "--quiet", ref, "--").WithDir(into).Execute(ctx, nil, &stdout, &stderr); err != nil {
+		_ = os.RemoveAll(into)
+		return nil, fmt.Errorf("failed to check out %s: %s: %w", ref, strings.TrimSpace(stderr.String()), err)
+	}
 	return repository, nil
 }
+
+// DefaultBranch asks GitHub for the default branch of repo, in the form owner/name
+func DefaultBranch(ctx context.Context, gitHubClient gogithub.GitHub, repo string) (string, error) {
+	owner, name, found := strings.Cut(repo, "/")
+	if !found {
+		return "", fmt.Errorf("invalid repo %q: expected owner/name", repo)
+	}
+	info, err := gitHubClient.RepositoryInfo(ctx, owner, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get repository info for %s: %w", repo, err)
+	}
+	branch := string(info.Repository.DefaultBranchRef.Name)
+	if branch == "" {
+		return "", fmt.Errorf("repo %s has no default branch", repo)
+	}
+	return branch, nil
+}
@@ -0,0 +1,47 @@
+package atlantisgithub
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/cresta/gogit"
+	"github.com/stretchr/testify/require"
+)
+
+func git(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+}
+
+func TestCheckOutRepo(t *testing.T) {
+	origin := t.TempDir()
+	git(t, origin, "init", "--quiet", "--initial-branch=master")
+	require.NoError(t, os.WriteFile(filepath.Join(origin, "atlantis.yaml"), []byte("version: 3\n"), 0o600))
+	git(t, origin, "add", ".")
+	git(t, origin, "commit", "--quiet", "-m", "master")
+	git(t, origin, "checkout", "--quiet", "-b", "main")
+	require.NoError(t, os.WriteFile(filepath.Join(origin, "atlantis.yaml"), []byte("version: 3\nprojects: []\n"), 0o600))
+	git(t, origin, "commit", "--quiet", "-am", "main")
+	git(t, origin, "checkout", "--quiet", "master")
+
+	cloner := &gogit.Cloner{Logger: gogit.SilentLogger{}, TempDir: t.TempDir()}
+	repo, err := CheckOutRepo(context.Background(), cloner, origin, "main")
+	require.NoError(t, err)
+	b, err := os.ReadFile(filepath.Join(repo.Location(), "atlantis.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "version: 3\nprojects: []\n", string(b))
+
+	repo, err = CheckOutRepo(context.Background(), cloner, origin, "")
+	require.NoError(t, err)
+	b, err = os.ReadFile(filepath.Join(repo.Location(), "atlantis.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "version: 3\n", string(b))
+
+	_, err = CheckOutRepo(context.Background(), cloner, origin, "missing-branch")
+	require.ErrorContains(t, err, "failed to check out missing-branch")
+}
@@ -0,0 +1,542 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/filter"
+	"github.com/cresta/atlantis-drift-detection/internal/scheduler"
+	"github.com/joeshaw/envdecode"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is read, if it exists, when no config file is given
+const DefaultPath = "drift-detection.yaml"
+
+// Config is the full configuration.  It is read from an optional YAML file, then overridden by environment variables.
+type Config struct {
+	// Repo is shorthand for a single entry in Repos
+	Repo string `yaml:"repo" env:"REPO"`
+	// Ref is the git ref planned, unless a repo sets its own.  If empty, the default branch is looked up from GitHub.
+	Ref string `yaml:"ref" env:"REPO_REF"`
+	// VCSType is the Atlantis VCS type of the repos, unless a repo sets its own
+	VCSType string `yaml:"vcs_type" env:"VCS_TYPE"`
+	// CloneURL, if set, is cloned instead of Repo from GitHub.  Only used with Repo; each of Repos sets its own.
+	CloneURL           string   `yaml:"clone_url" env:"CLONE_URL"`
+	Repos              []Repo   `yaml:"repos"`
+	Atlantis           Atlantis `yaml:"atlantis"`
+	DirectoryWhitelist []string `yaml:"directory_whitelist" env:"DIRECTORY_WHITELIST"`
+	Filters            Filters  `yaml:"filters"`
+	// Priorities weigh projects so the heaviest are checked first in every run
+	Priorities         Priorities `yaml:"priorities" env:"PRIORITIES"`
+	SkipWorkspaceCheck bool       `yaml:"skip_workspace_check" env:"SKIP_WORKSPACE_CHECK"`
+	// TerraformPluginMirror is a read-only provider mirror, such as one filled by "terraform providers mirror", that
+	// the workspace check installs providers from instead of downloading them for every directory
+	TerraformPluginMirror string `yaml:"terraform_plugin_mirror" env:"TERRAFORM_PLUGIN_MIRROR"`
+	ParallelRuns          int    `yaml:"parallel_runs" env:"PARALLEL_RUNS"`
+	Shard                 Shard  `yaml:"shard"`
+	// ContinueOnError checks every project even after some fail, reporting every failure at the end
+	ContinueOnError bool          `yaml:"continue_on_error" env:"CONTINUE_ON_ERROR"`
+	Cache           Cache         `yaml:"cache"`
+	Retry           Retry         `yaml:"retry"`
+	Timeouts        Timeouts      `yaml:"timeouts"`
+	Notifications   Notifications `yaml:"notifications"`
+	Schedule        Schedule      `yaml:"schedule"`
+	Server          Server        `yaml:"server"`
+	// ReportPath, if set, is where every run writes its report, with ".json" and ".md" appended
+	ReportPath      string        `yaml:"report_path" env:"REPORT_PATH"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	RunLockTTL      time.Duration `yaml:"run_lock_ttl" env:"RUN_LOCK_TTL"`
+}
+
+// Repo is one Terraform repository to check.  Anything not set falls back to the top level setting.
+type Repo struct {
+	// Name is the GitHub repository, for example "company/terraform"
+	Name               string        `yaml:"name"`
+	AtlantisConfigPath string        `yaml:"atlantis_config_path"`
+	Ref                string        `yaml:"ref"`
+	VCSType            string        `yaml:"vcs_type"`
+	CloneURL           string        `yaml:"clone_url"`
+	DirectoryWhitelist []string      `yaml:"directory_whitelist"`
+	Filters            Filters       `yaml:"filters"`
+	Priorities         Priorities    `yaml:"priorities"`
+	Notifications      Notifications `yaml:"notifications"`
+}
+
+// ResolvedRepos returns every repo to check, with top level settings filled in
+func (c *Config) ResolvedRepos() []Repo {
+	repos := c.Repos
+	if len(repos) == 0 && c.Repo != "" {
+		repos = []Repo{{Name: c.Repo, CloneURL: c.CloneURL}}
+	}
+	ret := make([]Repo, 0, len(repos))
+	for _, r := range repos {
+		if r.AtlantisConfigPath == "" {
+			r.AtlantisConfigPath = c.Atlantis.ConfigPath
+		}
+		if r.Ref == "" {
+			r.Ref = c.Ref
+		}
+		if r.VCSType == "" {
+			r.VCSType = c.VCSType
+		}
+		if len(r.DirectoryWhitelist) == 0 {
+			r.DirectoryWhitelist = c.DirectoryWhitelist
+		}
+		if r.Filters.empty() {
+			r.Filters = c.Filters
+		}
+		if len(r.Priorities) == 0 {
+			r.Priorities = c.Priorities
+		}
+		if r.Notifications.Slack.WebhookURL == "" {
+			r.Notifications.Slack = c.Notifications.Slack
+		}
+		if !r.Notifications.Workflow.enabled() {
+			r.Notifications.Workflow = c.Notifications.Workflow
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// Filters pick which projects are checked.  Exclude rules take precedence over include rules.
+type Filters struct {
+	Include Rules `yaml:"include" env:"FILTER_INCLUDE"`
+	Exclude Rules `yaml:"exclude" env:"FILTER_EXCLUDE"`
+}
+
+func (f Filters) empty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+func (f Filters) validate(field string) []error {
+	var errs []error
+	for i, r := range f.Include {
+		if err := r.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s.include[%d]: %w", field, i, err))
+		}
+	}
+	for i, r := range f.Exclude {
+		if err := r.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s.exclude[%d]: %w", field, i, err))
+		}
+	}
+	return errs
+}
+
+// Priorities are in the form "<weight>:<rule>" separated by ";" in the environment, with rules as in Rules
+type Priorities []filter.Priority
+
+func (p *Priorities) Decode(v string) error {
+	parsed, err := filter.ParsePriorities(v)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+func (p Priorities) validate(field string) []error {
+	var errs []error
+	for i, r := range p {
+		if err := r.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s[%d]: %w", field, i, err))
+		}
+	}
+	return errs
+}
+
+// Rules are in the form "[dir=]<pattern>[,workspace=<pattern>][,project=<pattern>]" separated by ";" in the
+// environment
+type Rules []filter.Rule
+
+func (r *Rules) Decode(v string) error {
+	parsed, err := filter.ParseRules(v)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+type Atlantis struct {
+	Host       string `yaml:"host" env:"ATLANTIS_HOST"`
+	Token      string `yaml:"token" env:"ATLANTIS_TOKEN"`
+	ConfigPath string `yaml:"config_path" env:"ATLANTIS_CONFIG_PATH"`
+	// PlansPerMinute limits how often plans are requested, so drift checks leave room for pull request plans.  Zero
+	// means no limit.
+	PlansPerMinute float64 `yaml:"plans_per_minute" env:"ATLANTIS_PLANS_PER_MINUTE"`
+	// PlanBurst is how many plans may be requested at once before PlansPerMinute applies
+	PlanBurst int `yaml:"plan_burst" env:"ATLANTIS_PLAN_BURST"`
+	// AdaptiveConcurrency lowers how many plans run at once while Atlantis returns overload errors, and raises it
+	// back up to parallel_runs once they stop
+	AdaptiveConcurrency bool `yaml:"adaptive_concurrency" env:"ATLANTIS_ADAPTIVE_CONCURRENCY"`
+}
+
+type Cache struct {
+	DynamodbTable string        `yaml:"dynamodb_table" env:"DYNAMODB_TABLE"`
+	ValidDuration time.Duration `yaml:"valid_duration" env:"CACHE_VALID_DURATION"`
+	// ErrorValidDuration is how long a check that kept failing is remembered before it is retried
+	ErrorValidDuration time.Duration `yaml:"error_valid_duration" env:"CACHE_ERROR_VALID_DURATION"`
+}
+
+// Retry controls retrying temporary Atlantis errors with exponential backoff and jitter.  One attempt means no retries,
+// and a MaxBackoff of zero leaves the backoff uncapped.
+type Retry struct {
+	Attempts       int           `yaml:"attempts" env:"RETRY_ATTEMPTS"`
+	InitialBackoff time.Duration `yaml:"initial_backoff" env:"RETRY_INITIAL_BACKOFF"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" env:"RETRY_MAX_BACKOFF"`
+}
+
+// Shard splits the projects between Count instances by hashing each directory and workspace.  Index is the shard this
+// instance checks, counting from 0.
+type Shard struct {
+	Index int `yaml:"index" env:"SHARD_INDEX"`
+	Count int `yaml:"count" env:"SHARD_COUNT"`
+}
+
+// Timeouts bound how long drift detection waits.  Zero means no limit.
+type Timeouts struct {
+	// Run bounds a whole run, across every repo
+	Run time.Duration `yaml:"run" env:"RUN_TIMEOUT"`
+	// Project bounds checking one project, including retries, and listing the workspaces of one directory
+	Project time.Duration `yaml:"project" env:"PROJECT_TIMEOUT"`
+}
+
+type Notifications struct {
+	Slack    Slack    `yaml:"slack"`
+	Workflow Workflow `yaml:"workflow"`
+}
+
+func (n Notifications) validate(field string) []error {
+	var errs []error
+	if n.Slack.WebhookURL != "" {
+		if u, err := url.Parse(n.Slack.WebhookURL); err != nil || u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("%s.slack.webhook_url: must be an https URL", field))
+		}
+	}
+	if w := n.Workflow; w.enabled() && (w.Owner == "" || w.Repo == "" || w.ID == "" || w.Ref == "") {
+		errs = append(errs, fmt.Errorf("%s.workflow: owner, repo, id and ref must all be set to trigger a workflow", field))
+	}
+	return errs
+}
+
+type Slack struct {
+	WebhookURL string `yaml:"webhook_url" env:"SLACK_WEBHOOK_URL"`
+	// Digest posts one summary per run instead of a message per drifted workspace
+	Digest bool `yaml:"digest" env:"SLACK_DIGEST"`
+}
+
+// Workflow is a GitHub workflow dispatched for each drifted directory.  Either all fields or none must be set.
+type Workflow struct {
+	Owner string `yaml:"owner" env:"WORKFLOW_OWNER"`
+	Repo  string `yaml:"repo" env:"WORKFLOW_REPO"`
+	ID    string `yaml:"id" env:"WORKFLOW_ID"`
+	Ref   string `yaml:"ref" env:"WORKFLOW_REF"`
+}
+
+func (w Workflow) enabled() bool {
+	return w.Owner != "" || w.Repo != "" || w.ID != "" || w.Ref != ""
+}
+
+type Schedule struct {
+	Cron         Schedules `yaml:"cron" env:"DRIFT_SCHEDULE"`
+	Timezone     string    `yaml:"timezone" env:"DRIFT_TIMEZONE"`
+	Groups       Groups    `yaml:"groups" env:"DRIFT_GROUP_SCHEDULES"`
+	RunOnStartup bool      `yaml:"run_on_startup" env:"RUN_ONCE_IMMEDIATELY_ON_STARTUP"`
+	// Trickle, if its interval is set, replaces Cron with small batches throughout the day
+	Trickle Trickle `yaml:"trickle"`
+}
+
+// Trickle checks the Batch projects of each repo most due for a check every Interval.  To check every project within
+// the cache's valid_duration, Batch needs to be at least projects * Interval / valid_duration.
+type Trickle struct {
+	Interval time.Duration `yaml:"interval" env:"TRICKLE_INTERVAL"`
+	Batch    int           `yaml:"batch" env:"TRICKLE_BATCH"`
+}
+
+// Location loads the timezone schedules are evaluated in
+func (s Schedule) Location() (*time.Location, error) {
+	return time.LoadLocation(s.Timezone)
+}
+
+// Schedules is a list of cron expressions.  In the environment they are ";" separated.
+type Schedules []string
+
+func (s *Schedules) Decode(v string) error {
+	*s = scheduler.SplitSchedules(v)
+	return nil
+}
+
+// UnmarshalYAML accepts either a single cron expression or a list of them
+func (s *Schedules) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*s = Schedules{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+// Group is a set of directories checked on their own schedules
+type Group struct {
+	Name        string    `yaml:"name"`
+	Cron        Schedules `yaml:"cron"`
+	Directories []string  `yaml:"directories"`
+}
+
+// Groups are in the form "<cron>=<dir>[,<dir>...]" separated by ";" in the environment
+type Groups []Group
+
+func (g *Groups) Decode(v string) error {
+	parsed, err := scheduler.ParseGroups(v)
+	if err != nil {
+		return err
+	}
+	ret := make(Groups, 0, len(parsed))
+	for _, p := range parsed {
+		ret = append(ret, Group{Name: p.Name, Cron: p.Schedules, Directories: p.Directories})
+	}
+	*g = ret
+	return nil
+}
+
+// SchedulerGroups converts the groups for the scheduler
+func (g Groups) SchedulerGroups() []scheduler.Group {
+	ret := make([]scheduler.Group, 0, len(g))
+	for _, group := range g {
+		ret = append(ret, scheduler.Group{Name: group.Name, Schedules: group.Cron, Directories: group.Directories})
+	}
+	return ret
+}
+
+type Server struct {
+	ListenAddress string `yaml:"listen_address" env:"LISTEN_ADDRESS"`
+	ControlToken  string `yaml:"control_token" env:"CONTROL_TOKEN"`
+}
+
+// Default returns the configuration used for anything not set in the file or environment
+func Default() *Config {
+	return &Config{
+		VCSType: atlantis.VCSGithub,
+		Atlantis: Atlantis{
+			ConfigPath: "atlantis.yaml",
+			PlanBurst:  1,
+		},
+		Cache: Cache{
+			ValidDuration:      24 * time.Hour,
+			ErrorValidDuration: time.Hour,
+		},
+		Retry: Retry{
+			Attempts:       1,
+			InitialBackoff: 10 * time.Second,
+			MaxBackoff:     2 * time.Minute,
+		},
+		Timeouts: Timeouts{
+			Project: 30 * time.Minute,
+		},
+		Schedule: Schedule{
+			Cron:     Schedules{"0 9 * * *"},
+			Timezone: "America/New_York",
+			Trickle: Trickle{
+				Batch: 5,
+			},
+		},
+		ShutdownTimeout: 30 * time.Second,
+		RunLockTTL:      5 * time.Minute,
+	}
+}
+
+// Load reads the YAML file at path, then applies environment variable overrides.  If path is empty, DefaultPath is
+// read if it exists.  The result is not validated.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	required := path != ""
+	if path == "" {
+		path = DefaultPath
+	}
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := cfg.decodeYAML(b); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	case os.IsNotExist(err) && !required:
+	default:
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	// StrictDecode reports no variables being set as ErrInvalidTarget, which is fine when everything is in the file
+	if err := envdecode.StrictDecode(cfg); err != nil && !errors.Is(err, envdecode.ErrInvalidTarget) {
+		return nil, fmt.Errorf("failed to decode config from environment: %w", err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) decodeYAML(b []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// Validate checks the configuration, returning every problem found
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	switch {
+	case c.Repo == "" && len(c.Repos) == 0:
+		fail("repo", "required (or set REPO), unless repos is set")
+	case c.Repo != "" && len(c.Repos) > 0:
+		fail("repo", "cannot be combined with repos, add %q to repos instead", c.Repo)
+	}
+	if c.CloneURL != "" && len(c.Repos) > 0 {
+		fail("clone_url", "only applies to repo, set clone_url on each of repos instead")
+	}
+	repoNames := make(map[string]bool)
+	for i, r := range c.Repos {
+		field := fmt.Sprintf("repos[%d]", i)
+		if owner, name, found := strings.Cut(r.Name, "/"); !found || owner == "" || name == "" {
+			fail(field+".name", "%q must be in the form owner/name", r.Name)
+		} else if repoNames[r.Name] {
+			fail(field+".name", "duplicate repo %q", r.Name)
+		}
+		repoNames[r.Name] = true
+		if r.VCSType != "" {
+			if err := atlantis.ValidateVCSType(r.VCSType); err != nil {
+				fail(field+".vcs_type", "%s", err)
+			}
+		}
+		errs = append(errs, r.Filters.validate(field+".filters")...)
+		errs = append(errs, r.Priorities.validate(field+".priorities")...)
+		errs = append(errs, r.Notifications.validate(field+".notifications")...)
+	}
+	if c.Atlantis.Host == "" {
+		fail("atlantis.host", "required (or set ATLANTIS_HOST)")
+	} else if u, err := url.Parse(c.Atlantis.Host); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fail("atlantis.host", "%q must be a URL like https://atlantis.example.com", c.Atlantis.Host)
+	}
+	if err := atlantis.ValidateVCSType(c.VCSType); err != nil {
+		fail("vcs_type", "%s", err)
+	}
+	for i, r := range c.ResolvedRepos() {
+		// The default branch and the clone URL can only be found through the GitHub API
+		if r.VCSType != atlantis.VCSGithub && (r.Ref == "" || r.CloneURL == "") {
+			field := "repo"
+			if len(c.Repos) > 0 {
+				field = fmt.Sprintf("repos[%d]", i)
+			}
+			fail(field, "ref and clone_url are required for %s repos", r.VCSType)
+		}
+	}
+	if c.Atlantis.Token == "" {
+		fail("atlantis.token", "required (or set ATLANTIS_TOKEN)")
+	}
+	if c.Atlantis.ConfigPath == "" {
+		fail("atlantis.config_path", "must not be empty")
+	}
+	if c.Atlantis.PlansPerMinute < 0 {
+		fail("atlantis.plans_per_minute", "must not be negative, got %g", c.Atlantis.PlansPerMinute)
+	}
+	if c.Atlantis.PlansPerMinute > 0 && c.Atlantis.PlanBurst < 1 {
+		fail("atlantis.plan_burst", "must be at least 1 when atlantis.plans_per_minute is set, got %d", c.Atlantis.PlanBurst)
+	}
+	if c.TerraformPluginMirror != "" && !filepath.IsAbs(c.TerraformPluginMirror) {
+		fail("terraform_plugin_mirror", "must be an absolute path, got %q", c.TerraformPluginMirror)
+	}
+	if c.ParallelRuns < 0 {
+		fail("parallel_runs", "must not be negative, got %d", c.ParallelRuns)
+	}
+	if c.Shard.Count < 0 {
+		fail("shard.count", "must not be negative, got %d", c.Shard.Count)
+	}
+	if c.Shard.Index < 0 || (c.Shard.Count > 0 && c.Shard.Index >= c.Shard.Count) || (c.Shard.Count == 0 && c.Shard.Index != 0) {
+		fail("shard.index", "must be at least 0 and less than shard.count (%d), got %d", c.Shard.Count, c.Shard.Index)
+	}
+	if c.Cache.ValidDuration < 0 {
+		fail("cache.valid_duration", "must not be negative, got %s", c.Cache.ValidDuration)
+	}
+	if c.Cache.ErrorValidDuration < 0 {
+		fail("cache.error_valid_duration", "must not be negative, got %s", c.Cache.ErrorValidDuration)
+	}
+	if c.Retry.Attempts < 0 {
+		fail("retry.attempts", "must not be negative, got %d", c.Retry.Attempts)
+	}
+	if c.Retry.InitialBackoff < 0 {
+		fail("retry.initial_backoff", "must not be negative, got %s", c.Retry.InitialBackoff)
+	}
+	// Zero leaves the backoff uncapped
+	if c.Retry.MaxBackoff < 0 {
+		fail("retry.max_backoff", "must not be negative, got %s", c.Retry.MaxBackoff)
+	} else if c.Retry.MaxBackoff > 0 && c.Retry.MaxBackoff < c.Retry.InitialBackoff {
+		fail("retry.max_backoff", "must be at least retry.initial_backoff (%s), got %s", c.Retry.InitialBackoff, c.Retry.MaxBackoff)
+	}
+	if c.Timeouts.Run < 0 {
+		fail("timeouts.run", "must not be negative, got %s", c.Timeouts.Run)
+	}
+	if c.Timeouts.Project < 0 {
+		fail("timeouts.project", "must not be negative, got %s", c.Timeouts.Project)
+	}
+	errs = append(errs, c.Filters.validate("filters")...)
+	errs = append(errs, c.Priorities.validate("priorities")...)
+	errs = append(errs, c.Notifications.validate("notifications")...)
+	if _, err := c.Schedule.Location(); err != nil {
+		fail("schedule.timezone", "%s", err)
+	}
+	for i, s := range c.Schedule.Cron {
+		if _, err := cron.ParseStandard(s); err != nil {
+			fail(fmt.Sprintf("schedule.cron[%d]", i), "invalid cron expression %q: %s", s, err)
+		}
+	}
+	if c.Schedule.Trickle.Interval < 0 {
+		fail("schedule.trickle.interval", "must not be negative, got %s", c.Schedule.Trickle.Interval)
+	}
+	if c.Schedule.Trickle.Interval > 0 && c.Schedule.Trickle.Batch < 1 {
+		fail("schedule.trickle.batch", "must be at least 1 when schedule.trickle.interval is set, got %d", c.Schedule.Trickle.Batch)
+	}
+	names := make(map[string]bool)
+	for i, g := range c.Schedule.Groups {
+		field := fmt.Sprintf("schedule.groups[%d]", i)
+		if g.Name == "" {
+			fail(field+".name", "required")
+		} else if names[g.Name] {
+			fail(field+".name", "duplicate group name %q", g.Name)
+		}
+		names[g.Name] = true
+		if len(g.Cron) == 0 {
+			fail(field+".cron", "at least one cron expression is required")
+		}
+		for j, s := range g.Cron {
+			if _, err := cron.ParseStandard(s); err != nil {
+				fail(fmt.Sprintf("%s.cron[%d]", field, j), "invalid cron expression %q: %s", s, err)
+			}
+		}
+		if len(g.Directories) == 0 {
+			fail(field+".directories", "at least one directory is required")
+		}
+	}
+	if c.ShutdownTimeout <= 0 {
+		fail("shutdown_timeout", "must be positive, got %s", c.ShutdownTimeout)
+	}
+	if c.RunLockTTL < 0 {
+		fail("run_lock_ttl", "must not be negative, got %s", c.RunLockTTL)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/filter"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "drift-detection.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(body), 0o600))
+	return path
+}
+
+func validConfig() *Config {
+	cfg := Default()
+	cfg.Repo = "company/terraform"
+	cfg.Atlantis.Host = "https://atlantis.example.com"
+	cfg.Atlantis.Token = "token"
+	return cfg
+}
+
+func TestLoad_FileWithEnvOverride(t *testing.T) {
+	path := writeConfig(t, `
+repo: company/terraform
+atlantis:
+  host: https://atlantis.example.com
+  token: from-file
+cache:
+  valid_duration: 168h
+timeouts:
+  run: 2h
+schedule:
+  cron: "@hourly"
+  timezone: Europe/Berlin
+  groups:
+    - name: prod
+      cron: ["0 * * * *", "30 * * * *"]
+      directories: [infra/prod]
+`)
+	t.Setenv("ATLANTIS_TOKEN", "from-env")
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, "company/terraform", cfg.Repo)
+	require.Equal(t, "from-env", cfg.Atlantis.Token)
+	require.Equal(t, "atlantis.yaml", cfg.Atlantis.ConfigPath)
+	require.Equal(t, 168*time.Hour, cfg.Cache.ValidDuration)
+	require.Equal(t, Timeouts{Run: 2 * time.Hour, Project: 30 * time.Minute}, cfg.Timeouts)
+	require.Equal(t, Schedules{"@hourly"}, cfg.Schedule.Cron)
+	require.Equal(t, "Europe/Berlin", cfg.Schedule.Timezone)
+	require.Equal(t, Groups{{Name: "prod", Cron: Schedules{"0 * * * *", "30 * * * *"}, Directories: []string{"infra/prod"}}}, cfg.Schedule.Groups)
+}
+
+func TestLoad_EnvGroups(t *testing.T) {
+	t.Setenv("DRIFT_GROUP_SCHEDULES", "@hourly=infra/prod;@weekly=infra/sandbox")
+	t.Setenv("DRIFT_SCHEDULE", "0 9 * * *;0 17 * * 1-5")
+	cfg, err := Load(writeConfig(t, ""))
+	require.NoError(t, err)
+	require.Equal(t, Schedules{"0 9 * * *", "0 17 * * 1-5"}, cfg.Schedule.Cron)
+	require.Len(t, cfg.Schedule.Groups, 2)
+	require.Equal(t, "group-2", cfg.Schedule.Groups[1].Name)
+}
+
+func TestLoad_NoEnvironment(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "repo: company/terraform\n"))
+	require.NoError(t, err)
+	require.Equal(t, "company/terraform", cfg.Repo)
+	require.Equal(t, Default().Schedule, cfg.Schedule)
+}
+
+func TestLoad_InvalidEnvironment(t *testing.T) {
+	t.Setenv("PARALLEL_RUNS", "lots")
+	_, err := Load(writeConfig(t, ""))
+	require.Error(t, err)
+}
+
+func TestLoad_UnknownField(t *testing.T) {
+	_, err := Load(writeConfig(t, "atlantis:\n  hostname: https://atlantis.example.com\n"))
+	require.ErrorContains(t, err, "field hostname not found")
+}
+
+func TestLoad_MissingFile(t *testing.T) {
+	_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, validConfig().Validate())
+
+	cfg := validConfig()
+	cfg.Repo = ""
+	cfg.Atlantis.Host = "atlantis.example.com"
+	cfg.Notifications.Workflow.Owner = "company"
+	cfg.Schedule.Timezone = "Mars/Olympus_Mons"
+	cfg.Schedule.Cron = Schedules{"every day"}
+	cfg.Schedule.Groups = Groups{{Name: "prod", Cron: Schedules{"@hourly"}}}
+	cfg.TerraformPluginMirror = "providers"
+	cfg.Timeouts.Project = -time.Minute
+	cfg.Atlantis.PlansPerMinute = 30
+	cfg.Atlantis.PlanBurst = 0
+	cfg.Shard = Shard{Index: 4, Count: 4}
+	cfg.Schedule.Trickle = Trickle{Interval: 5 * time.Minute}
+	err := cfg.Validate()
+	require.Error(t, err)
+	for _, field := range []string{"repo:", "atlantis.host:", "atlantis.plan_burst:", "shard.index:", "schedule.trickle.batch:", "terraform_plugin_mirror:", "timeouts.project:", "notifications.workflow:", "schedule.timezone:", "schedule.cron[0]:", "schedule.groups[0].directories:"} {
+		require.ErrorContains(t, err, field)
+	}
+}
+
+func TestValidate_Retry(t *testing.T) {
+	cfg := validConfig()
+	require.Equal(t, 1, cfg.Retry.Attempts)
+	cfg.Retry.MaxBackoff = 0
+	require.NoError(t, cfg.Validate())
+	cfg.Retry.MaxBackoff = time.Second
+	require.ErrorContains(t, cfg.Validate(), "retry.max_backoff: must be at least retry.initial_backoff")
+	cfg.Retry.MaxBackoff = -time.Second
+	require.ErrorContains(t, cfg.Validate(), "retry.max_backoff: must not be negative")
+}
+
+func TestResolvedRepos(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `
+atlantis:
+  host: https://atlantis.example.com
+  token: token
+directory_whitelist: [infra]
+notifications:
+  slack:
+    webhook_url: https://hooks.slack.com/services/X/Y/Z
+repos:
+  - name: company/terraform
+  - name: company/platform
+    ref: main
+    vcs_type: Gitlab
+    clone_url: https://gitlab.com/company/platform.git
+    atlantis_config_path: atlantis/atlantis.yaml
+    notifications:
+      slack:
+        webhook_url: https://hooks.slack.com/services/A/B/C
+`))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, []Repo{
+		{
+			Name:               "company/terraform",
+			AtlantisConfigPath: "atlantis.yaml",
+			VCSType:            "Github",
+			DirectoryWhitelist: []string{"infra"},
+			Notifications:      Notifications{Slack: Slack{WebhookURL: "https://hooks.slack.com/services/X/Y/Z"}},
+		},
+		{
+			Name:               "company/platform",
+			AtlantisConfigPath: "atlantis/atlantis.yaml",
+			Ref:                "main",
+			VCSType:            "Gitlab",
+			CloneURL:           "https://gitlab.com/company/platform.git",
+			DirectoryWhitelist: []string{"infra"},
+			Notifications:      Notifications{Slack: Slack{WebhookURL: "https://hooks.slack.com/services/A/B/C"}},
+		},
+	}, cfg.ResolvedRepos())
+
+	single := validConfig()
+	require.Equal(t, []string{"company/terraform"}, []string{single.ResolvedRepos()[0].Name})
+}
+
+func TestValidate_Repos(t *testing.T) {
+	cfg := validConfig()
+	cfg.Repos = []Repo{{Name: "company/platform"}, {Name: "company/platform"}, {Name: "platform"}}
+	err := cfg.Validate()
+	require.ErrorContains(t, err, "repo: cannot be combined with repos")
+	require.ErrorContains(t, err, "repos[1].name: duplicate repo")
+	require.ErrorContains(t, err, "repos[2].name:")
+}
+
+func TestLoad_Filters(t *testing.T) {
+	t.Setenv("FILTER_EXCLUDE", "**/sandbox/**;workspace=scratch-*")
+	cfg, err := Load(writeConfig(t, `
+priorities:
+  - dir: infra/prod/**
+    weight: 10
+filters:
+  include:
+    - dir: infra/terraform/**
+  exclude:
+    - project: "re:.*-tmp"
+`))
+	require.NoError(t, err)
+	require.Equal(t, Rules{{Dir: "infra/terraform/**"}}, cfg.Filters.Include)
+	require.Equal(t, Rules{{Dir: "**/sandbox/**"}, {Workspace: "scratch-*"}}, cfg.Filters.Exclude)
+	require.Equal(t, Priorities{{Rule: filter.Rule{Dir: "infra/prod/**"}, Weight: 10}}, cfg.Priorities)
+
+	cfg = validConfig()
+	cfg.Priorities = Priorities{{Weight: 10}}
+	require.ErrorContains(t, cfg.Validate(), "priorities[0]:")
+
+	cfg = validConfig()
+	cfg.Filters.Exclude = Rules{{Dir: "infra/["}, {}}
+	err = cfg.Validate()
+	require.ErrorContains(t, err, "filters.exclude[0]: invalid dir glob")
+	require.ErrorContains(t, err, "filters.exclude[1]:")
+}
+
+func TestValidate_VCSType(t *testing.T) {
+	cfg := validConfig()
+	cfg.VCSType = "Gitlab"
+	require.ErrorContains(t, cfg.Validate(), "repo: ref and clone_url are required for Gitlab repos")
+	cfg.Ref = "main"
+	cfg.CloneURL = "https://gitlab.com/company/terraform.git"
+	require.NoError(t, cfg.Validate())
+
+	cfg = validConfig()
+	cfg.Repo = ""
+	cfg.CloneURL = "https://gitlab.com/company/terraform.git"
+	cfg.Repos = []Repo{{Name: "company/terraform", VCSType: "Subversion"}}
+	err := cfg.Validate()
+	require.ErrorContains(t, err, "clone_url: only applies to repo")
+	require.ErrorContains(t, err, `repos[0].vcs_type: "Subversion" is not a valid type`)
+}
@@ -5,12 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
 	"github.com/cresta/atlantis-drift-detection/internal/atlantisgithub"
+	"github.com/cresta/atlantis-drift-detection/internal/filter"
+	"github.com/cresta/atlantis-drift-detection/internal/metrics"
 	"github.com/cresta/atlantis-drift-detection/internal/notification"
 	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/cresta/atlantis-drift-detection/internal/report"
 	"github.com/cresta/atlantis-drift-detection/internal/terraform"
 	"github.com/cresta/gogit"
 	"github.com/cresta/gogithub"
@@ -18,9 +25,19 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// notificationTimeout bounds a notification that is allowed to finish after the run is cancelled
+const notificationTimeout = 30 * time.Second
+
 type Drifter struct {
-	Logger             *zap.Logger
-	Repo               string
+	Logger *zap.Logger
+	Repo   string
+	// Ref is the git ref Atlantis plans and that is checked out to read the Atlantis config.  If empty, the default
+	// branch is looked up from GitHub on every run.
+	Ref string
+	// VCSType is the Atlantis VCS type of the repo, such as "Github" or "Gitlab".  Empty means GitHub.
+	VCSType string
+	// CloneURL, if set, is cloned instead of the GitHub repo
+	CloneURL           string
 	AtlantisConfigPath string
 	Cloner             *gogit.Cloner
 	GithubClient       gogithub.GitHub
@@ -29,19 +46,106 @@ type Drifter struct {
 	AtlantisClient     *atlantis.Client
 	ResultCache        processedcache.ProcessedCache
 	CacheValidDuration time.Duration
-	DirectoryWhitelist []string
+	// ErrorCacheDuration is how long a failed check is remembered before it is retried.  Zero retries on every run.
+	ErrorCacheDuration time.Duration
+	// Retry controls retrying temporary Atlantis errors within a run
+	Retry Retry
+	// ProjectTimeout bounds checking one project, including retries, and listing the workspaces of one directory.
+	// Zero means no limit.
+	ProjectTimeout time.Duration
+	// Filter picks which projects are checked.  Nil checks every project.
+	Filter *filter.Filter
+	// Priorities weigh projects so that, after the heaviest, projects that drifted last time and then those checked
+	// longest ago come first.  Nil weighs every project the same.
+	Priorities         *filter.Priorities
 	SkipWorkspaceCheck bool
 	ParallelRuns       int
+	// Shard, if enabled, limits this instance to its share of the projects
+	Shard Shard
+	// ContinueOnError checks every project even after some fail, then returns all the failures joined together
+	ContinueOnError bool
+	Metrics         *metrics.Metrics
+	// RunLeaseTTL, if set, makes runs take a lease in ResultCache so only one instance runs at a time
+	RunLeaseTTL time.Duration
+	// RunLeaseOwner identifies this instance when holding the run lease
+	RunLeaseOwner string
+
+	runMu sync.Mutex
+	// runRef is the ref being checked by the current run
+	runRef string
+	// runCache holds the cache values read by the current run, so picking, ordering and checking a project read its
+	// value once
+	runCacheMu sync.Mutex
+	runCache   map[string]*processedcache.DriftCheckValue
+}
+
+// RunOptions narrows a single drift run
+type RunOptions struct {
+	// Directories, if set, limits the run to these directories and anything nested below them
+	Directories []string
+	// Report, if set, is filled in by the run instead of a new report.  This lets callers watch progress.
+	Report *report.Report
+	// DryRun lists what would be planned without calling Atlantis, changing the cache or taking the run lease
+	DryRun bool
+	// Limit, if set, checks only this many of each repo's projects: those due for a check, highest priority first.
+	// Workspaces are only checked in directories with no due project left out of the batch.
+	Limit int
+}
+
+// Runner runs drift detection on demand
+type Runner interface {
+	Run(ctx context.Context, opts RunOptions) (*report.Report, error)
 }
 
 func (d *Drifter) Drift(ctx context.Context) error {
-	d.Logger.Info("Checking out repo", zap.String("repo", d.Repo))
-	repo, err := atlantisgithub.CheckOutTerraformRepo(ctx, d.GithubClient, d.Cloner, d.Repo)
+	_, err := d.Run(ctx, RunOptions{})
+	return err
+}
+
+// Run checks the repo for drift and reports the outcome of every project checked.  Runs on the same Drifter never
+// overlap: a second call waits for the first to finish.  If another instance holds the run lease, Run returns
+// ErrRunLocked without checking anything.
+func (d *Drifter) Run(ctx context.Context, opts RunOptions) (rep *report.Report, err error) {
+	d.runMu.Lock()
+	defer d.runMu.Unlock()
+	start := time.Now()
+	defer func() {
+		if !opts.DryRun {
+			d.Metrics.ObserveRun(d.Repo, time.Since(start), err)
+		}
+	}()
+	rep = opts.Report
+	if rep == nil {
+		rep = report.New(d.Repo)
+		opts.Report = rep
+	}
+	defer rep.Finish()
+	d.startRunCache()
+	defer d.stopRunCache()
+	release := func() {}
+	if !opts.DryRun {
+		ctx, release, err = d.holdRunLease(ctx)
+		if err != nil {
+			return rep, err
+		}
+	}
+	defer release()
+	if !opts.DryRun {
+		d.notifyRunStarted(ctx)
+		defer func() {
+			d.notifyRunCompleted(ctx, rep, start, err)
+		}()
+	}
+	d.runRef, err = d.resolveRef(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to checkout repo %s: %w", d.Repo, err)
+		return rep, err
 	}
-	d.Logger.Info("Repo checked out", zap.String("repo", d.Repo))
-	d.Terraform.Directory = repo.Location()
+	d.Logger.Info("Checking out repo", zap.String("repo", d.Repo), zap.String("ref", d.runRef))
+	repo, err := d.checkOut(ctx)
+	if err != nil {
+		return rep, fmt.Errorf("failed to checkout repo %s: %w", d.Repo, err)
+	}
+	d.Logger.Info("Repo checked out", zap.String("repo", d.Repo), zap.String("ref", d.runRef))
 	defer func() {
 		if err := os.RemoveAll(repo.Location()); err != nil {
 			d.Logger.Warn("failed to cleanup repo", zap.Error(err))
@@ -50,40 +154,158 @@ func (d *Drifter) Drift(ctx context.Context) error {
 	d.Logger.Debug("Parsing repo config", zap.String("repo", d.Repo))
 	cfg, err := atlantis.ParseRepoConfigFromDir(repo.Location(), d.AtlantisConfigPath)
 	if err != nil {
-		return fmt.Errorf("failed to parse repo config: %w", err)
+		return rep, fmt.Errorf("failed to parse repo config: %w", err)
+	}
+	allProjects := filterDirectories(atlantis.ConfigToProjects(cfg), opts.Directories)
+	projects := d.Shard.projects(allProjects)
+	if d.Shard.enabled() {
+		d.Logger.Info("Checking this shard's projects", zap.String("shard", d.Shard.String()), zap.Int("shard-projects", len(projects)), zap.Int("all-projects", len(allProjects)))
+	}
+	if opts.Limit > 0 {
+		var covered map[string]bool
+		projects, covered = d.mostDue(ctx, projects, opts.Limit)
+		allProjects = inDirectories(allProjects, covered)
+		rep.SetBatch()
+	}
+	rep.AddTotal(projects.Count())
+	d.Logger.Info("Found projects", zap.String("repo", d.Repo), zap.Stringers("projects", projects))
+	if err := d.checkRepo(ctx, repo.Location(), allProjects, projects, opts); err != nil {
+		return rep, err
+	}
+	d.Logger.Info("Drift check complete", zap.String("repo", d.Repo))
+	return rep, nil
+}
+
+// checkRepo compares the workspaces of allProjects' directories with their backends, then plans projects.  Workspaces
+// come first because Atlantis creates the workspace of every project it plans, which would hide missing ones.
+func (d *Drifter) checkRepo(ctx context.Context, checkout string, allProjects atlantis.Projects, projects atlantis.Projects, opts RunOptions) error {
+	if !opts.DryRun {
+		d.Logger.Debug("Finding extra workspaces", zap.String("repo", d.Repo))
+		// Workspaces are compared against every project in a directory, whichever shard plans them
+		if err := d.FindExtraWorkspaces(ctx, checkout, d.Shard.workspaces(allProjects.Workspaces()), opts); err != nil {
+			return fmt.Errorf("failed to find extra workspaces: %w", err)
+		}
 	}
-	workspaces := atlantis.ConfigToWorkspaces(cfg)
-	d.Logger.Info("Found workspaces", zap.String("repo", d.Repo), zap.Any("workspaces", workspaces))
 	d.Logger.Debug("Finding drifted workspaces", zap.String("repo", d.Repo))
-	if err := d.FindDriftedWorkspaces(ctx, workspaces); err != nil {
+	if err := d.FindDriftedWorkspaces(ctx, projects, opts); err != nil {
 		return fmt.Errorf("failed to find drifted workspaces: %w", err)
 	}
-	// TOPHER: turned off check because it's causing errors
-	// d.Logger.Debug("Finding extra workspaces", zap.String("repo", d.Repo))
-	// if err := d.FindExtraWorkspaces(ctx, workspaces); err != nil {
-	// 	return fmt.Errorf("failed to find extra workspaces: %w", err)
-	// }
-	d.Logger.Info("Drift check complete", zap.String("repo", d.Repo))
 	return nil
 }
 
-func (d *Drifter) shouldSkipDirectory(dir string) bool {
-	if len(d.DirectoryWhitelist) == 0 {
-		return false
+// resolveRef returns the ref to check, looking up the default branch if none is configured
+func (d *Drifter) resolveRef(ctx context.Context) (string, error) {
+	if d.Ref != "" {
+		return d.Ref, nil
+	}
+	ref, err := atlantisgithub.DefaultBranch(ctx, d.GithubClient, d.Repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the default branch of %s: %w", d.Repo, err)
+	}
+	return ref, nil
+}
+
+func (d *Drifter) checkOut(ctx context.Context) (*gogit.Repository, error) {
+	if d.CloneURL != "" {
+		return atlantisgithub.CheckOutRepo(ctx, d.Cloner, d.CloneURL, d.runRef)
+	}
+	return atlantisgithub.CheckOutTerraformRepo(ctx, d.GithubClient, d.Cloner, d.Repo, d.runRef)
+}
+
+// vcsType is the type sent to Atlantis with every plan request
+func (d *Drifter) vcsType() string {
+	if d.VCSType == "" {
+		return atlantis.VCSGithub
+	}
+	return d.VCSType
+}
+
+// record adds a checked project to the report and metrics
+func (d *Drifter) record(rep *report.Report, p report.Project) {
+	p.Repo = d.Repo
+	rep.Add(p)
+	if !p.Outcome.Checked() {
+		return
+	}
+	d.Metrics.ProjectChecked(d.Repo, string(p.Outcome))
+	switch p.Outcome {
+	case report.OutcomeClean, report.OutcomeDrifted:
+		d.Metrics.SetProjectDrifted(d.Repo, p.Dir, p.Workspace, p.Name, p.Outcome == report.OutcomeDrifted)
+	}
+}
+
+func (d *Drifter) notifyRunStarted(ctx context.Context) {
+	notifyCtx, cancel := notificationContext(ctx)
+	defer cancel()
+	if err := d.Notification.RunStarted(notifyCtx, d.Repo); err != nil {
+		d.Logger.Warn("failed to notify that the run started", zap.Error(err))
+	}
+}
+
+// notifyRunCompleted sends this repo's part of the report, which may be shared with other repos
+func (d *Drifter) notifyRunCompleted(ctx context.Context, rep *report.Report, start time.Time, runErr error) {
+	repoRep := rep.ForRepo(d.Repo)
+	repoRep.Started = start
+	repoRep.Finished = time.Now()
+	notifyCtx, cancel := notificationContext(ctx)
+	defer cancel()
+	if err := d.Notification.RunCompleted(notifyCtx, repoRep, runErr); err != nil {
+		d.Logger.Warn("failed to notify that the run completed", zap.Error(err))
+	}
+}
+
+// notificationContext lets a notification for drift we already found be sent even if the run is being cancelled
+func notificationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), notificationTimeout)
+}
+
+// inDirectories keeps only the projects in one of dirs
+func inDirectories(projects atlantis.Projects, dirs map[string]bool) atlantis.Projects {
+	var ret atlantis.Projects
+	for _, p := range projects {
+		if dirs[p.Dir] {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// filterDirectories keeps only the projects in directories equal to, or nested below, one of dirs.  An empty dirs
+// keeps everything.
+func filterDirectories(projects atlantis.Projects, dirs []string) atlantis.Projects {
+	if len(dirs) == 0 {
+		return projects
 	}
-	for _, whitelist := range d.DirectoryWhitelist {
-		if dir == whitelist {
-			return false
+	var ret atlantis.Projects
+	for _, p := range projects {
+		for _, d := range dirs {
+			d = strings.TrimSuffix(d, "/")
+			if p.Dir == d || strings.HasPrefix(p.Dir, d+"/") {
+				ret = append(ret, p)
+				break
+			}
 		}
 	}
-	return true
+	return ret
 }
 
 type errFunc func(ctx context.Context) error
 
+// drainAndExecute runs toRun on ParallelRuns workers.  Unless ContinueOnError is set, the first error cancels the rest.
 func (d *Drifter) drainAndExecute(ctx context.Context, toRun []errFunc) error {
+	if d.ContinueOnError {
+		return d.drainAndCollect(ctx, toRun)
+	}
+	return d.drainFailFast(ctx, toRun)
+}
+
+// drainFailFast runs toRun until the first error, which cancels everything still running
+func (d *Drifter) drainFailFast(ctx context.Context, toRun []errFunc) error {
 	if d.ParallelRuns <= 1 {
 		for _, r := range toRun {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			if err := r(ctx); err != nil {
 				return err
 			}
@@ -123,151 +345,374 @@ func (d *Drifter) drainAndExecute(ctx context.Context, toRun []errFunc) error {
 	return eg.Wait()
 }
 
-func (d *Drifter) FindDriftedWorkspaces(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces) error {
+// drainAndCollect runs every function, even after some fail, and returns all of their errors joined.  It only stops
+// early if ctx is cancelled.
+func (d *Drifter) drainAndCollect(ctx context.Context, toRun []errFunc) error {
+	var mu sync.Mutex
+	var errs []error
+	collecting := make([]errFunc, 0, len(toRun))
+	for _, r := range toRun {
+		collecting = append(collecting, func(ctx context.Context) error {
+			if err := r(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	// Only cancellation can fail, since every function returns nil
+	if err := d.drainFailFast(ctx, collecting); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// FindDriftedWorkspaces plans every project not already in the cache, recording each outcome in opts.Report.  Projects
+// in the same directory are checked one after another.
+func (d *Drifter) FindDriftedWorkspaces(ctx context.Context, projects atlantis.Projects, opts RunOptions) error {
+	rep := opts.Report
+	byDir := projects.ByDirectory()
 	runningFunc := func(dir string) errFunc {
 		return func(ctx context.Context) error {
-			if d.shouldSkipDirectory(dir) {
-				d.Logger.Info("Skipping directory", zap.String("dir", dir))
-				return nil
-			}
-			workspaces := ws[dir]
 			d.Logger.Info("Checking for drifted workspaces", zap.String("dir", dir))
-			for _, workspace := range workspaces {
-				cacheKey := &processedcache.ConsiderDriftChecked{
-					Dir:       dir,
-					Workspace: workspace,
+			var errs []error
+			for _, project := range byDir[dir] {
+				checked, rule := d.Filter.Check(project)
+				if !checked {
+					d.Logger.Info("Skipping project", zap.Stringer("project", project), zap.String("reason", rule))
+					d.record(rep, report.Project{Name: project.Name, Dir: dir, Workspace: project.Workspace, Outcome: report.OutcomeSkippedFilter, Reason: rule})
+					continue
 				}
-				cacheVal, err := d.ResultCache.GetDriftCheckResult(ctx, cacheKey)
-				if err != nil {
-					return fmt.Errorf("failed to get cache value for %s/%s: %w", dir, workspace, err)
+				start := time.Now()
+				projectCtx, cancel := d.projectContext(ctx)
+				p, err := d.checkProject(projectCtx, project, opts.DryRun)
+				timedOut := errors.Is(projectCtx.Err(), context.DeadlineExceeded)
+				cancel()
+				if opts.DryRun && rule != "" {
+					p.Reason += ", " + rule
 				}
-				if cacheVal != nil {
-					if time.Since(cacheVal.When) < d.CacheValidDuration {
-						d.Logger.Info("Skipping workspace, already checked", zap.String("dir", dir), zap.String("workspace", workspace))
-						continue
-					}
-					d.Logger.Info("Cache expired, checking again", zap.String("dir", dir), zap.String("workspace", workspace), zap.Duration("cache-age", time.Since(cacheVal.When)), zap.Duration("cache-valid-duration", d.CacheValidDuration))
-					if err := d.ResultCache.DeleteDriftCheckResult(ctx, cacheKey); err != nil {
-						return fmt.Errorf("failed to delete cache value for %s/%s: %w", dir, workspace, err)
+				p.Name = project.Name
+				p.Dir = dir
+				p.Workspace = project.Workspace
+				p.Started = start
+				p.DurationSeconds = time.Since(start).Seconds()
+				if err != nil {
+					if timedOut {
+						p.Outcome = report.OutcomeTimedOut
+					} else if p.Outcome == "" {
+						p.Outcome = report.OutcomeFailed
 					}
+					p.Error = err.Error()
 				}
-
-				pr, err := d.AtlantisClient.PlanSummary(ctx, &atlantis.PlanSummaryRequest{
-					Repo:      d.Repo,
-					Ref:       "master",
-					Type:      "Github",
-					Dir:       dir,
-					Workspace: workspace,
-				})
+				d.record(rep, p)
 				if err != nil {
-					var tmp atlantis.TemporaryError
-					if errors.As(err, &tmp) && tmp.Temporary() {
-						d.Logger.Warn("Temporary error.  Will try again later.", zap.Error(err))
-						continue
+					if ctx.Err() != nil {
+						return ctx.Err()
 					}
-					return fmt.Errorf("failed to get plan summary for (%s#%s): %w", dir, workspace, err)
-				}
-				if err := d.ResultCache.StoreDriftCheckResult(ctx, cacheKey, &processedcache.DriftCheckValue{
-					When:  time.Now(),
-					Error: "",
-					Drift: pr.HasChanges(),
-				}); err != nil {
-					return fmt.Errorf("failed to store cache value for %s/%s: %w", dir, workspace, err)
-				}
-				if pr.IsLocked() {
-					d.Logger.Info("Plan is locked, skipping drift check", zap.String("dir", dir))
-					continue
-				}
-				if pr.HasChanges() {
-					// Get the Terraform output from the first summary that has changes
-					// This allows us to include the drift details in the notification
-					var terraformOutput string
-					for _, summary := range pr.Summaries {
-						if !summary.HasLock && summary.TerraformOutput != "" {
-							terraformOutput = summary.TerraformOutput
-							break
-						}
+					if timedOut {
+						// Nothing was cached, so the next run checks it again
+						d.Logger.Warn("Project timed out", zap.Stringer("project", project), zap.Duration("timeout", d.ProjectTimeout))
+						continue
 					}
-
-					// Pass the terraform output as a variadic parameter
-					// If empty, the notification implementations will handle it gracefully
-					if err := d.Notification.PlanDrift(ctx, dir, workspace, terraformOutput); err != nil {
-						return fmt.Errorf("failed to notify of plan drift in %s: %w", dir, err)
+					if !d.ContinueOnError {
+						return err
 					}
+					errs = append(errs, err)
 				}
 			}
-			return nil
+			return errors.Join(errs...)
 		}
 	}
 	runs := make([]errFunc, 0)
-	for _, dir := range ws.SortedKeys() {
+	for _, dir := range d.prioritize(ctx, byDir) {
 		runs = append(runs, runningFunc(dir))
 	}
 	return d.drainAndExecute(ctx, runs)
 }
 
-func (d *Drifter) FindExtraWorkspaces(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces) error {
-	if d.SkipWorkspaceCheck {
+// checkProject plans one project, unless the cache says it was checked recently, and notifies of any drift.  The
+// returned project has its outcome filled in, even when there is also an error.
+func (d *Drifter) checkProject(ctx context.Context, project atlantis.Project, dryRun bool) (report.Project, error) {
+	dir, workspace := project.Dir, project.Workspace
+	cacheKey := &processedcache.ConsiderDriftChecked{
+		Repo:      d.Repo,
+		Dir:       dir,
+		Workspace: workspace,
+		Project:   project.Name,
+	}
+	cacheVal, err := d.getDriftCheckResult(ctx, cacheKey)
+	if err != nil {
+		return report.Project{}, fmt.Errorf("failed to get cache value for %s: %w", project, err)
+	}
+	if cacheVal != nil && time.Since(cacheVal.When) < d.cacheTTL(cacheVal) {
+		if !dryRun {
+			d.Metrics.CacheHit()
+		}
+		d.Logger.Info("Skipping project, already checked", zap.Stringer("project", project))
+		reason := fmt.Sprintf("checked %s ago, cached for %s", time.Since(cacheVal.When).Round(time.Second), d.CacheValidDuration)
+		if cacheVal.Error != "" {
+			reason = fmt.Sprintf("failed %s ago, retried after %s", time.Since(cacheVal.When).Round(time.Second), d.ErrorCacheDuration)
+		}
+		p := report.Project{Outcome: report.OutcomeSkippedCache, Reason: reason, CachedDrift: cacheVal.Drift}
+		addDriftHistory(&p, cacheVal)
+		return p, nil
+	}
+	if dryRun {
+		reason := "not in the cache"
+		if cacheVal != nil {
+			reason = fmt.Sprintf("cache expired, checked %s ago", time.Since(cacheVal.When).Round(time.Second))
+		}
+		return report.Project{Outcome: report.OutcomePlanned, Reason: reason}, nil
+	}
+	d.Metrics.CacheMiss()
+	if cacheVal != nil {
+		// The expired value is kept until a new one overwrites it, so a plan that times out or fails keeps the drift
+		// history for the next run
+		d.Logger.Info("Cache expired, checking again", zap.Stringer("project", project), zap.Duration("cache-age", time.Since(cacheVal.When)), zap.Duration("cache-valid-duration", d.cacheTTL(cacheVal)))
+	}
+
+	pr, err := d.planWithRetry(ctx, &atlantis.PlanSummaryRequest{
+		Repo:      d.Repo,
+		Ref:       d.runRef,
+		Type:      d.vcsType(),
+		Dir:       dir,
+		Workspace: workspace,
+		Project:   project.Name,
+	})
+	if err != nil {
+		// A deadline looks like a temporary error, but it should not be cached as one
+		if isTemporary(err) && ctx.Err() == nil {
+			return d.temporaryError(ctx, cacheKey, cacheVal, err)
+		}
+		return report.Project{}, fmt.Errorf("failed to get plan summary for (%s): %w", project, err)
+	}
+	now := time.Now()
+	value := &processedcache.DriftCheckValue{When: now}
+	switch {
+	case pr.IsLocked():
+		// A locked plan says nothing about drift, so keep what the last check found
+		value.KeepDrift(cacheVal)
+	case pr.HasChanges():
+		value.Detected(cacheVal, now)
+	}
+	if err := d.ResultCache.StoreDriftCheckResult(ctx, cacheKey, value); err != nil {
+		return report.Project{}, fmt.Errorf("failed to store cache value for %s: %w", project, err)
+	}
+	if pr.IsLocked() {
+		d.Logger.Info("Plan is locked, skipping drift check", zap.Stringer("project", project))
+		p := report.Project{Outcome: report.OutcomeLocked}
+		addDriftHistory(&p, value)
+		return p, nil
+	}
+	counts := pr.Counts()
+	if !pr.HasChanges() {
+		p := report.Project{Outcome: report.OutcomeClean, Changes: &counts}
+		if cacheVal == nil || !cacheVal.Drift {
+			return p, nil
+		}
+		p.Resolved = true
+		d.Logger.Info("Drift resolved", zap.Stringer("project", project))
+		notifyCtx, cancel := notificationContext(ctx)
+		defer cancel()
+		if err := d.Notification.DriftResolved(notifyCtx, location(cacheKey)); err != nil {
+			return p, fmt.Errorf("failed to notify of resolved drift in %s: %w", project, err)
+		}
+		return p, nil
+	}
+	p := report.Project{Outcome: report.OutcomeDrifted, Changes: &counts}
+	addDriftHistory(&p, value)
+	// Get the Terraform output from the first summary that has changes
+	// This allows us to include the drift details in the notification
+	var terraformOutput string
+	for _, summary := range pr.Summaries {
+		if !summary.HasLock && summary.TerraformOutput != "" {
+			terraformOutput = summary.TerraformOutput
+			break
+		}
+	}
+
+	// Pass the terraform output as a variadic parameter
+	// If empty, the notification implementations will handle it gracefully
+	notifyCtx, cancel := notificationContext(ctx)
+	defer cancel()
+	loc := location(cacheKey)
+	loc.DriftingSince = value.FirstDetected
+	loc.Detections = value.Detections
+	if err := d.Notification.PlanDrift(notifyCtx, loc, terraformOutput); err != nil {
+		return p, fmt.Errorf("failed to notify of plan drift in %s: %w", project, err)
+	}
+	return p, nil
+}
+
+// temporaryError remembers a plan that kept failing, so it is retried after ErrorCacheDuration instead of the full
+// cache duration, and notifies of it.  Drift found before the error is kept, so fixing it is still noticed later.
+func (d *Drifter) temporaryError(ctx context.Context, cacheKey *processedcache.ConsiderDriftChecked, previous *processedcache.DriftCheckValue, planErr error) (report.Project, error) {
+	d.Logger.Warn("Temporary error.  Will try again later.", zap.String("dir", cacheKey.Dir), zap.String("workspace", cacheKey.Workspace), zap.String("project", cacheKey.Project), zap.Error(planErr))
+	p := report.Project{Outcome: report.OutcomeTemporaryError, Error: planErr.Error()}
+	if d.ErrorCacheDuration > 0 {
+		value := &processedcache.DriftCheckValue{
+			When:  time.Now(),
+			Error: planErr.Error(),
+		}
+		value.KeepDrift(previous)
+		if err := d.ResultCache.StoreDriftCheckResult(ctx, cacheKey, value); err != nil {
+			return p, fmt.Errorf("failed to store cache value for %s: %w", cacheKey, err)
+		}
+	}
+	notifyCtx, cancel := notificationContext(ctx)
+	defer cancel()
+	if err := d.Notification.TemporaryError(notifyCtx, location(cacheKey), planErr); err != nil {
+		return p, fmt.Errorf("failed to notify of temporary error in %s: %w", cacheKey.Dir, err)
+	}
+	return p, nil
+}
+
+// projectContext applies ProjectTimeout, if set
+func (d *Drifter) projectContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.ProjectTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d.ProjectTimeout)
+}
+
+// addDriftHistory copies how long the project has been drifted into the report, if it is drifted
+func addDriftHistory(p *report.Project, v *processedcache.DriftCheckValue) {
+	if v == nil || !v.Drift || v.FirstDetected.IsZero() {
+		return
+	}
+	first, last := v.FirstDetected, v.LastDetected
+	p.FirstDetected = &first
+	p.LastDetected = &last
+	p.Detections = v.Detections
+}
+
+func location(key *processedcache.ConsiderDriftChecked) notification.Location {
+	return notification.Location{Directory: key.Dir, Workspace: key.Workspace, Project: key.Project}
+}
+
+// FindExtraWorkspaces lists the workspaces in the backend of every directory of the checkout and notifies of any Atlantis does not
+// know about, and of any Atlantis plans in that the backend does not have.  Each directory is initialized in its own TF_DATA_DIR, so directories can be checked in parallel.
+// Failures, including backend authentication failures, are recorded in opts.Report and do not stop the run.
+func (d *Drifter) FindExtraWorkspaces(ctx context.Context, checkout string, ws atlantis.DirectoriesWithWorkspaces, opts RunOptions) error {
+	if d.SkipWorkspaceCheck || len(ws) == 0 {
 		return nil
 	}
-	runFunc := func(dir string) errFunc {
+	dataRoot, err := os.MkdirTemp("", "terraform-data")
+	if err != nil {
+		return fmt.Errorf("failed to create terraform data directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dataRoot); err != nil {
+			d.Logger.Warn("failed to cleanup terraform data directory", zap.Error(err))
+		}
+	}()
+	runFunc := func(dir string, dataDir string) errFunc {
 		return func(ctx context.Context) error {
-			if d.shouldSkipDirectory(dir) {
-				d.Logger.Info("Skipping directory", zap.String("dir", dir))
+			if checked, rule := d.Filter.CheckDirectory(dir); !checked {
+				d.Logger.Info("Skipping directory", zap.String("dir", dir), zap.String("reason", rule))
 				return nil
 			}
-			cacheKey := &processedcache.ConsiderWorkspacesChecked{
-				Dir: dir,
-			}
-			cacheVal, err := d.ResultCache.GetRemoteWorkspaces(ctx, cacheKey)
-			if err != nil {
-				return fmt.Errorf("failed to get cache value for %s: %w", dir, err)
+			dirCtx, cancel := d.projectContext(ctx)
+			check, err := d.checkWorkspaces(dirCtx, checkout, dir, ws[dir], dataDir)
+			cancel()
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-			if cacheVal != nil {
-				if time.Since(cacheVal.When) < d.CacheValidDuration {
-					d.Logger.Info("Skipping directory, in cache", zap.String("dir", dir))
-					return nil
-				}
-				d.Logger.Info("Cache expired, checking again", zap.String("dir", dir), zap.Duration("cache-age", time.Since(cacheVal.When)), zap.Duration("cache-valid-duration", d.CacheValidDuration))
-				if err := d.ResultCache.DeleteRemoteWorkspaces(ctx, cacheKey); err != nil {
-					return fmt.Errorf("failed to delete cache value for %s: %w", dir, err)
-				}
-			}
-			workspaces := ws[dir]
-			d.Logger.Info("Checking for extra workspaces", zap.String("dir", dir))
-			if err := d.Terraform.Init(ctx, dir); err != nil {
-				return fmt.Errorf("failed to init workspace %s: %w", dir, err)
+			if check == nil {
+				return nil
 			}
-			var expectedWorkspaces []string
-			expectedWorkspaces = append(expectedWorkspaces, workspaces...)
-			expectedWorkspaces = append(expectedWorkspaces, "default")
-			remoteWorkspaces, err := d.Terraform.ListWorkspaces(ctx, dir)
 			if err != nil {
-				return fmt.Errorf("failed to list workspaces in %s: %w", dir, err)
-			}
-			for _, w := range remoteWorkspaces {
-				if !contains(expectedWorkspaces, w) {
-					if err := d.Notification.ExtraWorkspaceInRemote(ctx, dir, w); err != nil {
-						return fmt.Errorf("failed to notify of extra workspace %s in %s: %w", w, dir, err)
-					}
+				var authErr *terraform.BackendAuthError
+				check.Outcome = report.WorkspaceOutcomeFailed
+				if errors.As(err, &authErr) {
+					check.Outcome = report.WorkspaceOutcomeBackendAuth
 				}
+				check.Error = err.Error()
+				d.Logger.Warn("Failed to check workspaces", zap.String("dir", dir), zap.String("outcome", string(check.Outcome)), zap.Error(err))
 			}
-			if err := d.ResultCache.StoreRemoteWorkspaces(ctx, cacheKey, &processedcache.WorkspacesCheckedValue{
-				Workspaces: remoteWorkspaces,
-				When:       time.Now(),
-			}); err != nil {
-				return fmt.Errorf("failed to store cache value for %s: %w", dir, err)
-			}
+			check.Repo = d.Repo
+			check.Dir = dir
+			opts.Report.AddWorkspaceCheck(*check)
 			return nil
 		}
 	}
 	runs := make([]errFunc, 0)
-	for _, dir := range ws.SortedKeys() {
-		runs = append(runs, runFunc(dir))
+	for i, dir := range ws.SortedKeys() {
+		runs = append(runs, runFunc(dir, filepath.Join(dataRoot, strconv.Itoa(i))))
 	}
 	return d.drainAndExecute(ctx, runs)
 }
 
+// checkWorkspaces compares the workspaces in the backend of dir, within checkout, with the ones the Atlantis config
+// declares.  It returns nil if the cache says dir was checked recently.
+func (d *Drifter) checkWorkspaces(ctx context.Context, checkout string, dir string, workspaces []string, dataDir string) (*report.WorkspaceCheck, error) {
+	check := &report.WorkspaceCheck{}
+	cacheKey := &processedcache.ConsiderWorkspacesChecked{
+		Repo: d.Repo,
+		Dir:  dir,
+	}
+	cacheVal, err := d.ResultCache.GetRemoteWorkspaces(ctx, cacheKey)
+	if err != nil {
+		return check, fmt.Errorf("failed to get cache value for %s: %w", dir, err)
+	}
+	if cacheVal != nil {
+		if time.Since(cacheVal.When) < d.CacheValidDuration {
+			d.Logger.Info("Skipping directory, in cache", zap.String("dir", dir))
+			return nil, nil
+		}
+		d.Logger.Info("Cache expired, checking again", zap.String("dir", dir), zap.Duration("cache-age", time.Since(cacheVal.When)), zap.Duration("cache-valid-duration", d.CacheValidDuration))
+		if err := d.ResultCache.DeleteRemoteWorkspaces(ctx, cacheKey); err != nil {
+			return check, fmt.Errorf("failed to delete cache value for %s: %w", dir, err)
+		}
+	}
+	d.Logger.Info("Checking for extra workspaces", zap.String("dir", dir))
+	if err := d.Terraform.Init(ctx, filepath.Join(checkout, dir), dataDir); err != nil {
+		return check, fmt.Errorf("failed to init workspace %s: %w", dir, err)
+	}
+	var expectedWorkspaces []string
+	expectedWorkspaces = append(expectedWorkspaces, workspaces...)
+	expectedWorkspaces = append(expectedWorkspaces, "default")
+	remoteWorkspaces, err := d.Terraform.ListWorkspaces(ctx, filepath.Join(checkout, dir), dataDir)
+	if err != nil {
+		return check, fmt.Errorf("failed to list workspaces in %s: %w", dir, err)
+	}
+	check.Outcome = report.WorkspaceOutcomeOK
+	check.Remote = remoteWorkspaces
+	for _, w := range remoteWorkspaces {
+		if !contains(expectedWorkspaces, w) {
+			check.Outcome = report.WorkspaceOutcomeExtra
+			check.Extra = append(check.Extra, w)
+		}
+	}
+	// A project planned in a workspace that does not exist usually means a typo, or a migration that was never run
+	for _, w := range workspaces {
+		if !contains(remoteWorkspaces, w) && !contains(check.Missing, w) {
+			check.Outcome = report.WorkspaceOutcomeMissing
+			check.Missing = append(check.Missing, w)
+		}
+	}
+	notifyCtx, cancel := notificationContext(ctx)
+	defer cancel()
+	for _, w := range check.Extra {
+		if err := d.Notification.ExtraWorkspaceInRemote(notifyCtx, dir, w); err != nil {
+			return check, fmt.Errorf("failed to notify of extra workspace %s in %s: %w", w, dir, err)
+		}
+	}
+	for _, w := range check.Missing {
+		if err := d.Notification.MissingWorkspaceInRemote(notifyCtx, dir, w); err != nil {
+			return check, fmt.Errorf("failed to notify of missing workspace %s in %s: %w", w, dir, err)
+		}
+	}
+	if err := d.ResultCache.StoreRemoteWorkspaces(ctx, cacheKey, &processedcache.WorkspacesCheckedValue{
+		Workspaces: remoteWorkspaces,
+		When:       time.Now(),
+	}); err != nil {
+		return check, fmt.Errorf("failed to store cache value for %s: %w", dir, err)
+	}
+	return check, nil
+}
+
 func contains(workspaces []string, w string) bool {
 	for _, workspace := range workspaces {
 		if workspace == w {
@@ -276,3 +721,5 @@ func contains(workspaces []string, w string) bool {
 	}
 	return false
 }
+
+var _ Runner = &Drifter{}
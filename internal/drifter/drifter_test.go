@@ -2,10 +2,26 @@ package drifter
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/filter"
+	"github.com/cresta/atlantis-drift-detection/internal/notification"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+	"github.com/cresta/atlantis-drift-detection/internal/terraform"
+	"github.com/cresta/gogithub"
+	"github.com/shurcooL/githubv4"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
 )
 
 // MockNotification implements the Notification interface for testing
@@ -14,9 +30,17 @@ type MockNotification struct {
 	LastDir             string
 	LastWorkspace       string
 	LastTerraformOutput string
+	MissingWorkspaces   []string
+	DriftResolvedCalled bool
+	LastLocation        notification.Location
 }
 
-func (m *MockNotification) TemporaryError(_ context.Context, _ string, _ string, _ error) error {
+func (m *MockNotification) DriftResolved(_ context.Context, _ notification.Location) error {
+	m.DriftResolvedCalled = true
+	return nil
+}
+
+func (m *MockNotification) TemporaryError(_ context.Context, _ notification.Location, _ error) error {
 	return nil
 }
 
@@ -24,14 +48,24 @@ func (m *MockNotification) ExtraWorkspaceInRemote(_ context.Context, _ string, _
 	return nil
 }
 
-func (m *MockNotification) MissingWorkspaceInRemote(_ context.Context, _ string, _ string) error {
+func (m *MockNotification) MissingWorkspaceInRemote(_ context.Context, dir string, workspace string) error {
+	m.MissingWorkspaces = append(m.MissingWorkspaces, dir+"#"+workspace)
+	return nil
+}
+
+func (m *MockNotification) RunStarted(_ context.Context, _ string) error {
+	return nil
+}
+
+func (m *MockNotification) RunCompleted(_ context.Context, _ *report.Report, _ error) error {
 	return nil
 }
 
-func (m *MockNotification) PlanDrift(_ context.Context, dir string, workspace string, terraformOutput ...string) error {
+func (m *MockNotification) PlanDrift(_ context.Context, loc notification.Location, terraformOutput ...string) error {
 	m.PlanDriftCalled = true
-	m.LastDir = dir
-	m.LastWorkspace = workspace
+	m.LastLocation = loc
+	m.LastDir = loc.Directory
+	m.LastWorkspace = loc.Workspace
 	if len(terraformOutput) > 0 {
 		m.LastTerraformOutput = terraformOutput[0]
 	}
@@ -67,7 +101,7 @@ func TestDrifter_UsesPlanDriftWithTerraformOutputWhenAvailable(t *testing.T) {
 		}
 
 		// Pass the terraform output as a variadic parameter
-		err := mockNotification.PlanDrift(context.Background(), dir, workspace, terraformOutput)
+		err := mockNotification.PlanDrift(context.Background(), notification.Location{Directory: dir, Workspace: workspace}, terraformOutput)
 		require.NoError(t, err)
 	}
 
@@ -107,7 +141,7 @@ func TestDrifter_UsesPlanDriftWithoutTerraformOutputWhenNotAvailable(t *testing.
 		}
 
 		// Pass the terraform output as a variadic parameter (will be empty)
-		err := mockNotification.PlanDrift(context.Background(), dir, workspace, terraformOutput)
+		err := mockNotification.PlanDrift(context.Background(), notification.Location{Directory: dir, Workspace: workspace}, terraformOutput)
 		require.NoError(t, err)
 	}
 
@@ -117,3 +151,367 @@ func TestDrifter_UsesPlanDriftWithoutTerraformOutputWhenNotAvailable(t *testing.
 	require.Equal(t, workspace, mockNotification.LastWorkspace)
 	require.Equal(t, "", mockNotification.LastTerraformOutput)
 }
+
+func TestFilterDirectories(t *testing.T) {
+	projects := atlantis.Projects{
+		{Dir: "infra/prod/db", Workspace: "default"},
+		{Dir: "infra/prod-old/db", Workspace: "default"},
+		{Dir: "infra/staging/db", Workspace: "default"},
+	}
+	require.Equal(t, projects, filterDirectories(projects, nil))
+	require.Equal(t, atlantis.Projects{
+		{Dir: "infra/prod/db", Workspace: "default"},
+	}, filterDirectories(projects, []string{"infra/prod/"}))
+	require.Equal(t, atlantis.Projects{
+		{Dir: "infra/staging/db", Workspace: "default"},
+	}, filterDirectories(projects, []string{"infra/staging/db"}))
+}
+
+func TestDrifter_DrainAndExecuteStopsWhenCancelled(t *testing.T) {
+	for _, parallel := range []int{1, 4} {
+		d := &Drifter{ParallelRuns: parallel}
+		ctx, cancel := context.WithCancel(context.Background())
+		var ran atomic.Int32
+		runs := []errFunc{
+			func(_ context.Context) error {
+				ran.Add(1)
+				cancel()
+				return nil
+			},
+		}
+		for i := 0; i < 50; i++ {
+			runs = append(runs, func(_ context.Context) error {
+				ran.Add(1)
+				return nil
+			})
+		}
+		require.ErrorIs(t, d.drainAndExecute(ctx, runs), context.Canceled)
+		if parallel == 1 {
+			require.Equal(t, int32(1), ran.Load())
+			continue
+		}
+		// Workers already running may finish what they picked up, but the rest are never started
+		require.GreaterOrEqual(t, ran.Load(), int32(1))
+		require.Less(t, ran.Load(), int32(len(runs)))
+	}
+}
+
+func mustFilter(t *testing.T, whitelist []string, include []filter.Rule, exclude []filter.Rule) *filter.Filter {
+	f, err := filter.New(whitelist, include, exclude)
+	require.NoError(t, err)
+	return f
+}
+
+type fakeCache struct {
+	processedcache.Noop
+	results map[string]*processedcache.DriftCheckValue
+}
+
+func (f *fakeCache) GetDriftCheckResult(_ context.Context, key *processedcache.ConsiderDriftChecked) (*processedcache.DriftCheckValue, error) {
+	return f.results[key.String()], nil
+}
+
+func TestDrifter_FindDriftedWorkspacesDryRun(t *testing.T) {
+	d := &Drifter{
+		Logger:             zaptest.NewLogger(t),
+		Repo:               "company/terraform",
+		Filter:             mustFilter(t, []string{"infra/prod", "infra/shared"}, []filter.Rule{{Dir: "infra/**"}}, []filter.Rule{{Project: "*-scratch"}}),
+		CacheValidDuration: 24 * time.Hour,
+		ResultCache: &fakeCache{results: map[string]*processedcache.DriftCheckValue{
+			"company/terraform:infra/shared:default":                  {When: time.Now().Add(-time.Hour)},
+			"company/terraform:infra/prod:default:prod-audit-logging": {When: time.Now().Add(-time.Hour)},
+		}},
+		// AtlantisClient is left nil: a dry run must never plan
+	}
+	rep := report.New(d.Repo)
+	projects := atlantis.Projects{
+		{Name: "prod", Dir: "infra/prod", Workspace: "default"},
+		{Name: "prod-audit-logging", Dir: "infra/prod", Workspace: "default"},
+		{Name: "prod-scratch", Dir: "infra/prod", Workspace: "default"},
+		{Dir: "infra/shared", Workspace: "default"},
+		{Dir: "infra/sandbox", Workspace: "default"},
+	}
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), projects, RunOptions{Report: rep, DryRun: true}))
+	outcomes := make(map[string]report.Outcome)
+	reasons := make(map[string]string)
+	for _, p := range rep.Projects {
+		outcomes[p.Dir+"#"+p.Name] = p.Outcome
+		reasons[p.Dir+"#"+p.Name] = p.Reason
+	}
+	require.Equal(t, map[string]report.Outcome{
+		"infra/prod#prod":               report.OutcomePlanned,
+		"infra/prod#prod-audit-logging": report.OutcomeSkippedCache,
+		"infra/prod#prod-scratch":       report.OutcomeSkippedFilter,
+		"infra/shared#":                 report.OutcomeSkippedCache,
+		"infra/sandbox#":                report.OutcomeSkippedFilter,
+	}, outcomes)
+	require.Equal(t, "not in the cache, included by rule dir=infra/**", reasons["infra/prod#prod"])
+	require.Equal(t, "excluded by rule project=*-scratch", reasons["infra/prod#prod-scratch"])
+	require.Equal(t, "not in the directory whitelist", reasons["infra/sandbox#"])
+}
+
+func TestDrifter_DrainAndExecuteContinueOnError(t *testing.T) {
+	for _, parallel := range []int{1, 4} {
+		d := &Drifter{ParallelRuns: parallel, ContinueOnError: true}
+		var ran atomic.Int32
+		errA := errors.New("plan failed for infra/a")
+		errB := errors.New("plan failed for infra/b")
+		runs := []errFunc{
+			func(_ context.Context) error {
+				ran.Add(1)
+				return errA
+			},
+			func(_ context.Context) error {
+				ran.Add(1)
+				return nil
+			},
+			func(_ context.Context) error {
+				ran.Add(1)
+				return errB
+			},
+		}
+		err := d.drainAndExecute(context.Background(), runs)
+		require.ErrorIs(t, err, errA)
+		require.ErrorIs(t, err, errB)
+		require.Equal(t, int32(3), ran.Load())
+	}
+}
+
+type fakeGitHub struct {
+	gogithub.GitHub
+	defaultBranch string
+}
+
+func (f *fakeGitHub) RepositoryInfo(_ context.Context, _ string, _ string) (*gogithub.RepositoryInfo, error) {
+	var ret gogithub.RepositoryInfo
+	ret.Repository.DefaultBranchRef.Name = githubv4.String(f.defaultBranch)
+	return &ret, nil
+}
+
+func TestDrifter_ResolveRef(t *testing.T) {
+	d := &Drifter{Repo: "company/terraform", GithubClient: &fakeGitHub{defaultBranch: "main"}}
+	ref, err := d.resolveRef(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "main", ref)
+	require.Equal(t, "Github", d.vcsType())
+
+	d.Ref = "release"
+	d.VCSType = "Gitlab"
+	ref, err = d.resolveRef(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "release", ref)
+	require.Equal(t, "Gitlab", d.vcsType())
+}
+
+// fakeTerraform puts a terraform script on PATH that lists the workspaces "default" and "old", and fails init with a
+// credentials error in any directory named noauth
+func fakeTerraform(t *testing.T) {
+	bin := t.TempDir()
+	script := `#!/bin/sh
+if [ -z "$TF_DATA_DIR" ]; then
+  echo "TF_DATA_DIR not set" >&2
+  exit 1
+fi
+case "$1" in
+init)
+  if [ "$(basename "$PWD")" = noauth ]; then
+    echo "Error: error configuring S3 Backend: NoCredentialProviders: no valid providers in chain" >&2
+    exit 1
+  fi
+  if [ "$(basename "$PWD")" = broken ]; then
+    echo "Error: Unsupported argument" >&2
+    exit 1
+  fi
+  ;;
+workspace)
+  printf '* default\n  old\n  prod\n'
+  ;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "terraform"), []byte(script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestDrifter_FindExtraWorkspaces(t *testing.T) {
+	fakeTerraform(t)
+	checkout := t.TempDir()
+	for _, dir := range []string{"infra/prod", "infra/noauth", "infra/broken"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(checkout, dir), 0o755))
+	}
+	notif := &MockNotification{}
+	d := &Drifter{
+		Logger:       zaptest.NewLogger(t),
+		Repo:         "company/terraform",
+		ResultCache:  processedcache.Noop{},
+		Notification: notif,
+		Terraform:    &terraform.Client{Logger: zaptest.NewLogger(t)},
+		ParallelRuns: 3,
+	}
+	rep := report.New(d.Repo)
+	require.NoError(t, d.FindExtraWorkspaces(context.Background(), checkout, atlantis.DirectoriesWithWorkspaces{
+		"infra/prod":   {"prod", "staging"},
+		"infra/noauth": {"prod"},
+		"infra/broken": {"prod"},
+	}, RunOptions{Report: rep}))
+	checks := rep.SortedWorkspaceChecks()
+	require.Len(t, checks, 3)
+	require.Equal(t, report.WorkspaceOutcomeFailed, checks[0].Outcome)
+	require.Contains(t, checks[0].Error, "Unsupported argument")
+	require.Equal(t, report.WorkspaceOutcomeBackendAuth, checks[1].Outcome)
+	require.Equal(t, report.WorkspaceCheck{
+		Repo:    "company/terraform",
+		Dir:     "infra/prod",
+		Outcome: report.WorkspaceOutcomeMissing,
+		Remote:  []string{"default", "old", "prod"},
+		Extra:   []string{"old"},
+		Missing: []string{"staging"},
+	}, checks[2])
+	require.Equal(t, []string{"infra/prod#staging"}, notif.MissingWorkspaces)
+	_, err := os.Stat(filepath.Join(checkout, "infra/prod/.terraform"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestDrifter_CheckProjectDriftResolved(t *testing.T) {
+	client, _ := fakeAtlantis(t, 0)
+	cache := &storingCache{previous: &processedcache.DriftCheckValue{When: time.Now().Add(-48 * time.Hour), Drift: true}}
+	notif := &MockNotification{}
+	d := &Drifter{
+		Logger:             zaptest.NewLogger(t),
+		AtlantisClient:     client,
+		ResultCache:        cache,
+		Notification:       notif,
+		CacheValidDuration: 24 * time.Hour,
+	}
+	p, err := d.checkProject(context.Background(), atlantis.Project{Dir: "infra/prod", Workspace: "default"}, false)
+	require.NoError(t, err)
+	require.Equal(t, report.OutcomeClean, p.Outcome)
+	require.True(t, p.Resolved)
+	require.True(t, notif.DriftResolvedCalled)
+	require.False(t, cache.stored.Drift)
+
+	// Only a change from drifted to clean is a resolution
+	notif.DriftResolvedCalled = false
+	cache.previous = cache.stored
+	cache.previous.When = time.Now().Add(-48 * time.Hour)
+	p, err = d.checkProject(context.Background(), atlantis.Project{Dir: "infra/prod", Workspace: "default"}, false)
+	require.NoError(t, err)
+	require.False(t, p.Resolved)
+	require.False(t, notif.DriftResolvedCalled)
+}
+
+func TestDrifter_CheckProjectTemporaryErrorKeepsDrift(t *testing.T) {
+	client, _ := fakeAtlantis(t, 10)
+	cache := &storingCache{previous: &processedcache.DriftCheckValue{When: time.Now().Add(-48 * time.Hour), Drift: true}}
+	d := &Drifter{
+		Logger:             zaptest.NewLogger(t),
+		AtlantisClient:     client,
+		ResultCache:        cache,
+		Notification:       &MockNotification{},
+		Retry:              Retry{Attempts: 1},
+		CacheValidDuration: 24 * time.Hour,
+		ErrorCacheDuration: time.Hour,
+	}
+	p, err := d.checkProject(context.Background(), atlantis.Project{Dir: "infra/prod", Workspace: "default"}, false)
+	require.NoError(t, err)
+	require.Equal(t, report.OutcomeTemporaryError, p.Outcome)
+	require.True(t, cache.stored.Drift)
+}
+
+func TestDrifter_CheckProjectTracksDriftHistory(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults": [{"PlanSuccess": {"TerraformOutput": "Plan: 1 to add, 0 to change, 0 to destroy."}}]}`))
+	}))
+	defer srv.Close()
+	firstDetected := time.Now().Add(-9 * 24 * time.Hour)
+	cache := &storingCache{previous: &processedcache.DriftCheckValue{
+		When:          time.Now().Add(-48 * time.Hour),
+		Drift:         true,
+		FirstDetected: firstDetected,
+		LastDetected:  time.Now().Add(-48 * time.Hour),
+		Detections:    4,
+	}}
+	notif := &MockNotification{}
+	d := &Drifter{
+		Logger:             zaptest.NewLogger(t),
+		AtlantisClient:     &atlantis.Client{AtlantisHostname: srv.URL, HTTPClient: srv.Client()},
+		ResultCache:        cache,
+		Notification:       notif,
+		CacheValidDuration: 24 * time.Hour,
+	}
+	p, err := d.checkProject(context.Background(), atlantis.Project{Dir: "infra/prod", Workspace: "default"}, false)
+	require.NoError(t, err)
+	require.Equal(t, report.OutcomeDrifted, p.Outcome)
+	require.Equal(t, 5, p.Detections)
+	require.Equal(t, firstDetected, *p.FirstDetected)
+	require.Equal(t, 5, cache.stored.Detections)
+	require.Equal(t, firstDetected, cache.stored.FirstDetected)
+	require.Equal(t, firstDetected, notif.LastLocation.DriftingSince)
+	require.Equal(t, 5, notif.LastLocation.Detections)
+}
+
+func TestDrifter_FindDriftedWorkspacesCachedDrift(t *testing.T) {
+	d := &Drifter{
+		Logger:             zaptest.NewLogger(t),
+		Repo:               "company/terraform",
+		CacheValidDuration: 168 * time.Hour,
+		ResultCache: &fakeCache{results: map[string]*processedcache.DriftCheckValue{
+			"company/terraform:infra/prod:default":   {When: time.Now().Add(-time.Hour), Drift: true},
+			"company/terraform:infra/shared:default": {When: time.Now().Add(-time.Hour)},
+		}},
+		// AtlantisClient is left nil: both projects are cached, so nothing is planned
+	}
+	rep := report.New(d.Repo)
+	projects := atlantis.Projects{
+		{Dir: "infra/prod", Workspace: "default"},
+		{Dir: "infra/shared", Workspace: "default"},
+	}
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), projects, RunOptions{Report: rep}))
+	require.Equal(t, 2, rep.Count(report.OutcomeSkippedCache))
+	require.True(t, rep.HasDrift())
+}
+
+func TestDrifter_CheckRepoFindsMissingWorkspacesBeforePlanning(t *testing.T) {
+	// The backend is a directory with a file per workspace beyond default.  Like Atlantis, planning creates the
+	// workspace it plans in.
+	backend := t.TempDir()
+	bin := t.TempDir()
+	script := `#!/bin/sh
+case "$1" in
+workspace)
+  echo "* default"
+  ls "` + backend + `"
+  ;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "terraform"), []byte(script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Paths []struct {
+				Workspace string
+			}
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.NoError(t, os.WriteFile(filepath.Join(backend, req.Paths[0].Workspace), nil, 0o644))
+		_, _ = w.Write([]byte(noChangesResponse))
+	}))
+	defer srv.Close()
+	checkout := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(checkout, "infra/prod"), 0o755))
+	notif := &MockNotification{}
+	d := &Drifter{
+		Logger:             zaptest.NewLogger(t),
+		Repo:               "company/terraform",
+		AtlantisClient:     &atlantis.Client{AtlantisHostname: srv.URL, HTTPClient: srv.Client()},
+		ResultCache:        processedcache.Noop{},
+		Notification:       notif,
+		Terraform:          &terraform.Client{Logger: zaptest.NewLogger(t)},
+		CacheValidDuration: 24 * time.Hour,
+	}
+	projects := atlantis.Projects{{Dir: "infra/prod", Workspace: "prod"}}
+	rep := report.New(d.Repo)
+	require.NoError(t, d.checkRepo(context.Background(), checkout, projects, projects, RunOptions{Report: rep}))
+	require.Equal(t, []string{"infra/prod#prod"}, notif.MissingWorkspaces)
+	require.Equal(t, 1, rep.Count(report.OutcomeClean))
+	require.FileExists(t, filepath.Join(backend, "prod"))
+}
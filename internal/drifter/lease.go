@@ -0,0 +1,76 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"go.uber.org/zap"
+)
+
+// ErrRunLocked is returned by Run when another instance holds the run lease
+var ErrRunLocked = errors.New("another instance is already running drift detection")
+
+// runLeaseName is per shard, so shards of the same repo can run at the same time
+func (d *Drifter) runLeaseName() string {
+	if d.Shard.enabled() {
+		return "drift-run:" + d.Repo + ":shard-" + d.Shard.String()
+	}
+	return "drift-run:" + d.Repo
+}
+
+// holdRunLease takes the run lease and renews it until release is called.  The returned context is cancelled if the
+// lease is lost, so the run stops instead of overlapping with another instance.
+func (d *Drifter) holdRunLease(ctx context.Context) (context.Context, func(), error) {
+	if d.RunLeaseTTL <= 0 {
+		return ctx, func() {}, nil
+	}
+	name := d.runLeaseName()
+	acquired, err := d.ResultCache.AcquireLease(ctx, name, d.RunLeaseOwner, d.RunLeaseTTL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to acquire run lease: %w", err)
+	}
+	if !acquired {
+		return nil, nil, ErrRunLocked
+	}
+	d.Logger.Info("Acquired run lease", zap.String("lease", name), zap.String("owner", d.RunLeaseOwner))
+	leaseCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(d.RunLeaseTTL / 3)
+		defer ticker.Stop()
+		lastRenewed := time.Now()
+		for {
+			select {
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := d.ResultCache.RenewLease(leaseCtx, name, d.RunLeaseOwner, d.RunLeaseTTL)
+			if err == nil {
+				lastRenewed = time.Now()
+				continue
+			}
+			if errors.Is(err, processedcache.ErrLeaseLost) || time.Since(lastRenewed) >= d.RunLeaseTTL {
+				d.Logger.Error("Lost run lease, stopping run", zap.String("lease", name), zap.Error(err))
+				cancel()
+				return
+			}
+			d.Logger.Warn("Failed to renew run lease, will retry", zap.String("lease", name), zap.Error(err))
+		}
+	}()
+	release := func() {
+		cancel()
+		<-done
+		// Release even if the run was cancelled, so another instance does not have to wait for the lease to expire
+		releaseCtx, releaseCancel := context.WithTimeout(context.WithoutCancel(ctx), notificationTimeout)
+		defer releaseCancel()
+		if err := d.ResultCache.ReleaseLease(releaseCtx, name, d.RunLeaseOwner); err != nil {
+			d.Logger.Warn("Failed to release run lease", zap.String("lease", name), zap.Error(err))
+		}
+	}
+	return leaseCtx, release, nil
+}
@@ -0,0 +1,67 @@
+package drifter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type fakeLeaser struct {
+	processedcache.Noop
+	held     bool
+	renewErr error
+	released chan struct{}
+}
+
+func (f *fakeLeaser) AcquireLease(_ context.Context, _ string, _ string, _ time.Duration) (bool, error) {
+	return !f.held, nil
+}
+
+func (f *fakeLeaser) RenewLease(_ context.Context, _ string, _ string, _ time.Duration) error {
+	return f.renewErr
+}
+
+func (f *fakeLeaser) ReleaseLease(_ context.Context, _ string, _ string) error {
+	close(f.released)
+	return nil
+}
+
+func TestDrifter_HoldRunLeaseHeldElsewhere(t *testing.T) {
+	d := &Drifter{
+		Logger:      zaptest.NewLogger(t),
+		ResultCache: &fakeLeaser{held: true},
+		RunLeaseTTL: time.Minute,
+	}
+	_, _, err := d.holdRunLease(context.Background())
+	require.ErrorIs(t, err, ErrRunLocked)
+}
+
+func TestDrifter_HoldRunLeaseLost(t *testing.T) {
+	leaser := &fakeLeaser{renewErr: processedcache.ErrLeaseLost, released: make(chan struct{})}
+	d := &Drifter{
+		Logger:      zaptest.NewLogger(t),
+		ResultCache: leaser,
+		RunLeaseTTL: 30 * time.Millisecond,
+	}
+	ctx, release, err := d.holdRunLease(context.Background())
+	require.NoError(t, err)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("run context was not cancelled after losing the lease")
+	}
+	release()
+	<-leaser.released
+}
+
+func TestDrifter_HoldRunLeaseDisabled(t *testing.T) {
+	d := &Drifter{Logger: zaptest.NewLogger(t)}
+	ctx, release, err := d.holdRunLease(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, ctx.Err())
+	release()
+}
@@ -0,0 +1,89 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+	"go.uber.org/zap"
+)
+
+// Multi runs drift detection for several repos, one after another, filling a single report
+type Multi struct {
+	Logger   *zap.Logger
+	Drifters []*Drifter
+	// ReportPath, if set, is where the report of every run is written, with ".json" and ".md" appended
+	ReportPath string
+	// RunTimeout bounds a whole run, across every repo.  Zero means no limit.
+	RunTimeout time.Duration
+	// Shard is the share of projects the Drifters check.  It labels the report and is added to ReportPath.
+	Shard Shard
+}
+
+// Run checks every repo.  A repo that fails does not stop the others; their errors are joined.  If another instance
+// holds the run lease for every repo, Run returns ErrRunLocked.
+func (m *Multi) Run(ctx context.Context, opts RunOptions) (rep *report.Report, err error) {
+	if m.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.RunTimeout)
+		defer cancel()
+		defer func() {
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("run timed out after %s: %w", m.RunTimeout, err)
+			}
+		}()
+	}
+	if opts.Report == nil {
+		opts.Report = report.New(m.repoNames())
+	} else {
+		opts.Report.NameRepo(m.repoNames())
+	}
+	rep = opts.Report
+	rep.SetShard(m.Shard.String())
+	defer func() {
+		rep.Finish()
+		path := m.Shard.ReportPath(m.ReportPath)
+		if path == "" || opts.DryRun || errors.Is(err, ErrRunLocked) {
+			return
+		}
+		if err := rep.WriteFiles(path); err != nil {
+			m.Logger.Error("Failed to write run report", zap.String("path", path), zap.Error(err))
+			return
+		}
+		m.Logger.Info("Wrote run report", zap.String("path", path))
+	}()
+	var errs []error
+	locked := 0
+	for _, d := range m.Drifters {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		_, err := d.Run(ctx, opts)
+		switch {
+		case errors.Is(err, ErrRunLocked):
+			m.Logger.Info("Skipping repo, another instance is running it", zap.String("repo", d.Repo))
+			locked++
+		case err != nil:
+			m.Logger.Error("Drift detection failed for repo", zap.String("repo", d.Repo), zap.Error(err))
+			errs = append(errs, fmt.Errorf("repo %s: %w", d.Repo, err))
+		}
+	}
+	if len(m.Drifters) > 0 && locked == len(m.Drifters) {
+		return rep, ErrRunLocked
+	}
+	return rep, errors.Join(errs...)
+}
+
+func (m *Multi) repoNames() string {
+	names := make([]string, 0, len(m.Drifters))
+	for _, d := range m.Drifters {
+		names = append(names, d.Repo)
+	}
+	return strings.Join(names, ",")
+}
+
+var _ Runner = &Multi{}
@@ -0,0 +1,64 @@
+package drifter
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func lockedDrifter(t *testing.T, repo string) *Drifter {
+	return &Drifter{
+		Logger:      zaptest.NewLogger(t),
+		Repo:        repo,
+		ResultCache: &fakeLeaser{held: true},
+		RunLeaseTTL: time.Minute,
+	}
+}
+
+func TestMulti_AllReposLocked(t *testing.T) {
+	m := &Multi{
+		Logger:     zaptest.NewLogger(t),
+		Drifters:   []*Drifter{lockedDrifter(t, "company/terraform"), lockedDrifter(t, "company/platform")},
+		ReportPath: filepath.Join(t.TempDir(), "drift"),
+	}
+	rep, err := m.Run(context.Background(), RunOptions{})
+	require.ErrorIs(t, err, ErrRunLocked)
+	require.Equal(t, "company/terraform,company/platform", rep.Repo)
+	require.False(t, rep.Finished.IsZero())
+	// A report passed in without a repo, as the http server does, is named the same way
+	rep, err = m.Run(context.Background(), RunOptions{Report: report.New("")})
+	require.ErrorIs(t, err, ErrRunLocked)
+	require.Equal(t, "company/terraform,company/platform", rep.Repo)
+	// Another instance ran, so it writes the report
+	require.NoFileExists(t, m.ReportPath+".json")
+}
+
+func TestMulti_StopsWhenCancelled(t *testing.T) {
+	m := &Multi{
+		Logger:     zaptest.NewLogger(t),
+		Drifters:   []*Drifter{lockedDrifter(t, "company/terraform")},
+		ReportPath: filepath.Join(t.TempDir(), "drift"),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := m.Run(ctx, RunOptions{})
+	require.ErrorIs(t, err, context.Canceled)
+	require.FileExists(t, m.ReportPath+".json")
+	require.FileExists(t, m.ReportPath+".md")
+}
+
+func TestMulti_RunTimeout(t *testing.T) {
+	m := &Multi{
+		Logger:     zaptest.NewLogger(t),
+		Drifters:   []*Drifter{lockedDrifter(t, "company/terraform")},
+		RunTimeout: time.Nanosecond,
+	}
+	_, err := m.Run(context.Background(), RunOptions{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "run timed out after 1ns")
+}
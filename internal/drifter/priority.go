@@ -0,0 +1,154 @@
+package drifter
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"go.uber.org/zap"
+)
+
+// priority decides how early in a run a project is checked
+type priority struct {
+	checked bool
+	// due is true if the cache does not hold a current result, so checking the project plans it
+	due     bool
+	weight  int
+	drifted bool
+	// lastChecked is zero for projects never checked, so they come first
+	lastChecked time.Time
+}
+
+// before orders projects by weight, then projects that drifted last time, then the longest since they were checked
+func (p priority) before(other priority) bool {
+	switch {
+	case p.checked != other.checked:
+		return p.checked
+	case p.weight != other.weight:
+		return p.weight > other.weight
+	case p.drifted != other.drifted:
+		return p.drifted
+	default:
+		return p.lastChecked.Before(other.lastChecked)
+	}
+}
+
+func (d *Drifter) startRunCache() {
+	d.runCacheMu.Lock()
+	defer d.runCacheMu.Unlock()
+	d.runCache = make(map[string]*processedcache.DriftCheckValue)
+}
+
+func (d *Drifter) stopRunCache() {
+	d.runCacheMu.Lock()
+	defer d.runCacheMu.Unlock()
+	d.runCache = nil
+}
+
+// getDriftCheckResult reads key from ResultCache, or returns what the current run already read.  Every project is
+// checked once per run, so values the run stores are never read back.  Failed reads are not remembered.
+func (d *Drifter) getDriftCheckResult(ctx context.Context, key *processedcache.ConsiderDriftChecked) (*processedcache.DriftCheckValue, error) {
+	d.runCacheMu.Lock()
+	value, exists := d.runCache[key.String()]
+	d.runCacheMu.Unlock()
+	if exists {
+		return value, nil
+	}
+	value, err := d.ResultCache.GetDriftCheckResult(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	d.runCacheMu.Lock()
+	defer d.runCacheMu.Unlock()
+	if d.runCache != nil {
+		d.runCache[key.String()] = value
+	}
+	return value, nil
+}
+
+func (d *Drifter) projectPriority(ctx context.Context, project atlantis.Project) priority {
+	if checked, _ := d.Filter.Check(project); !checked {
+		return priority{}
+	}
+	ret := priority{checked: true, due: true, weight: d.Priorities.Weight(project)}
+	cacheVal, err := d.getDriftCheckResult(ctx, &processedcache.ConsiderDriftChecked{
+		Repo:      d.Repo,
+		Dir:       project.Dir,
+		Workspace: project.Workspace,
+		Project:   project.Name,
+	})
+	if err != nil {
+		// Ordering is best effort.  Checking the project reads the cache again and reports the error.
+		d.Logger.Warn("Unable to read cache to prioritize project", zap.Stringer("project", project), zap.Error(err))
+		return ret
+	}
+	if cacheVal != nil {
+		ret.due = time.Since(cacheVal.When) >= d.cacheTTL(cacheVal)
+		ret.drifted = cacheVal.Drift
+		ret.lastChecked = cacheVal.When
+	}
+	return ret
+}
+
+// mostDue picks up to limit of the projects that are due to be checked, in the order prioritize would check them.  It
+// also returns the directories the batch covers: those with no due project left out of it.
+func (d *Drifter) mostDue(ctx context.Context, projects atlantis.Projects, limit int) (atlantis.Projects, map[string]bool) {
+	type prioritized struct {
+		project  atlantis.Project
+		priority priority
+	}
+	var due []prioritized
+	for _, project := range projects {
+		if p := d.projectPriority(ctx, project); p.checked && p.due {
+			due = append(due, prioritized{project: project, priority: p})
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].priority.before(due[j].priority)
+	})
+	d.Logger.Info("Picked the projects most due for a check", zap.Int("due", len(due)), zap.Int("limit", limit))
+	n := min(limit, len(due))
+	ret := make(atlantis.Projects, 0, n)
+	covered := make(map[string]bool)
+	for _, p := range due[:n] {
+		ret = append(ret, p.project)
+		covered[p.project.Dir] = true
+	}
+	for _, p := range due[n:] {
+		delete(covered, p.project.Dir)
+	}
+	return ret, covered
+}
+
+// prioritize sorts the projects of each directory so the most valuable are checked first, and returns the directories
+// in the order of their most valuable project.  A run that is cut short then still covers what matters most, and the
+// projects it missed are the oldest next time.
+func (d *Drifter) prioritize(ctx context.Context, byDir atlantis.DirectoriesWithProjects) []string {
+	type prioritized struct {
+		project  atlantis.Project
+		priority priority
+	}
+	best := make(map[string]priority, len(byDir))
+	for dir, projects := range byDir {
+		ordered := make([]prioritized, 0, len(projects))
+		for _, project := range projects {
+			ordered = append(ordered, prioritized{project: project, priority: d.projectPriority(ctx, project)})
+		}
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i].priority.before(ordered[j].priority)
+		})
+		for i, o := range ordered {
+			projects[i] = o.project
+		}
+		if len(ordered) > 0 {
+			best[dir] = ordered[0].priority
+		}
+	}
+	dirs := byDir.SortedKeys()
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return best[dirs[i]].before(best[dirs[j]])
+	})
+	return dirs
+}
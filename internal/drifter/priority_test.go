@@ -0,0 +1,139 @@
+package drifter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/filter"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestDrifter_Prioritize(t *testing.T) {
+	priorities, err := filter.NewPriorities([]filter.Priority{{Rule: filter.Rule{Dir: "prod/**"}, Weight: 10}})
+	require.NoError(t, err)
+	now := time.Now()
+	d := &Drifter{
+		Logger:     zaptest.NewLogger(t),
+		Repo:       "company/terraform",
+		Filter:     mustFilter(t, nil, nil, []filter.Rule{{Dir: "aaa/skipped"}}),
+		Priorities: priorities,
+		ResultCache: &fakeCache{results: map[string]*processedcache.DriftCheckValue{
+			"company/terraform:prod/db:default":     {When: now.Add(-time.Hour)},
+			"company/terraform:prod/db:replica":     {When: now.Add(-2 * time.Hour)},
+			"company/terraform:prod/api:default":    {When: now.Add(-3 * time.Hour), Drift: true},
+			"company/terraform:dev/api:default":     {When: now.Add(-time.Hour), Drift: true},
+			"company/terraform:dev/db:default":      {When: now.Add(-48 * time.Hour)},
+			"company/terraform:dev/network:default": {When: now.Add(-time.Minute)},
+		}},
+	}
+	byDir := atlantis.Projects{
+		{Dir: "aaa/skipped", Workspace: "default"},
+		{Dir: "dev/api", Workspace: "default"},
+		{Dir: "dev/db", Workspace: "default"},
+		{Dir: "dev/network", Workspace: "default"},
+		{Dir: "dev/new", Workspace: "default"},
+		{Dir: "prod/api", Workspace: "default"},
+		{Dir: "prod/db", Workspace: "default"},
+		{Dir: "prod/db", Workspace: "replica"},
+	}.ByDirectory()
+	require.Equal(t, []string{
+		// Weighted first, with drift before age
+		"prod/api",
+		"prod/db",
+		// Then drift, then never checked, then the longest since checked
+		"dev/api",
+		"dev/new",
+		"dev/db",
+		"dev/network",
+		// Filtered out projects cost nothing, so they come last
+		"aaa/skipped",
+	}, d.prioritize(context.Background(), byDir))
+	require.Equal(t, "replica", byDir["prod/db"][0].Workspace)
+}
+
+func TestDrifter_MostDue(t *testing.T) {
+	now := time.Now()
+	d := &Drifter{
+		Logger:             zaptest.NewLogger(t),
+		Repo:               "company/terraform",
+		Filter:             mustFilter(t, nil, nil, []filter.Rule{{Dir: "skipped"}}),
+		CacheValidDuration: 24 * time.Hour,
+		ErrorCacheDuration: time.Hour,
+		ResultCache: &fakeCache{results: map[string]*processedcache.DriftCheckValue{
+			"company/terraform:fresh:default":  {When: now.Add(-time.Hour)},
+			"company/terraform:stale:default":  {When: now.Add(-25 * time.Hour)},
+			"company/terraform:staler:default": {When: now.Add(-48 * time.Hour)},
+			"company/terraform:failed:default": {When: now.Add(-2 * time.Hour), Error: "plan failed"},
+		}},
+	}
+	projects := atlantis.Projects{
+		{Dir: "fresh", Workspace: "default"},
+		{Dir: "stale", Workspace: "default"},
+		{Dir: "skipped", Workspace: "default"},
+		{Dir: "new", Workspace: "default"},
+		{Dir: "staler", Workspace: "default"},
+		{Dir: "failed", Workspace: "default"},
+		{Dir: "stale", Workspace: "replica"},
+	}
+	batch, covered := d.mostDue(context.Background(), projects, 3)
+	require.Equal(t, atlantis.Projects{
+		{Dir: "new", Workspace: "default"},
+		{Dir: "stale", Workspace: "replica"},
+		{Dir: "staler", Workspace: "default"},
+	}, batch)
+	// stale/default is still due, so the workspaces of stale wait for the batch that checks it
+	require.Equal(t, map[string]bool{"new": true, "staler": true}, covered)
+	batch, covered = d.mostDue(context.Background(), projects, 10)
+	require.Len(t, batch, 5)
+	require.Equal(t, map[string]bool{"failed": true, "new": true, "stale": true, "staler": true}, covered)
+
+	batch, covered = d.mostDue(context.Background(), projects[:1], 3)
+	require.Empty(t, batch)
+	require.Empty(t, covered)
+}
+
+type countingCache struct {
+	fakeCache
+	reads map[string]int
+}
+
+func (c *countingCache) GetDriftCheckResult(ctx context.Context, key *processedcache.ConsiderDriftChecked) (*processedcache.DriftCheckValue, error) {
+	c.reads[key.String()]++
+	return c.fakeCache.GetDriftCheckResult(ctx, key)
+}
+
+func TestDrifter_ReadsCacheOncePerRun(t *testing.T) {
+	cache := &countingCache{
+		fakeCache: fakeCache{results: map[string]*processedcache.DriftCheckValue{
+			"company/terraform:fresh:default": {When: time.Now().Add(-time.Hour)},
+		}},
+		reads: make(map[string]int),
+	}
+	d := &Drifter{
+		Logger:             zaptest.NewLogger(t),
+		Repo:               "company/terraform",
+		CacheValidDuration: 24 * time.Hour,
+		ResultCache:        cache,
+	}
+	ctx := context.Background()
+	projects := atlantis.Projects{{Dir: "fresh", Workspace: "default"}, {Dir: "new", Workspace: "default"}}
+	d.startRunCache()
+	batch, _ := d.mostDue(ctx, projects, 5)
+	require.Equal(t, atlantis.Projects{{Dir: "new", Workspace: "default"}}, batch)
+	d.prioritize(ctx, projects.ByDirectory())
+	p, err := d.checkProject(ctx, projects[0], true)
+	require.NoError(t, err)
+	require.Equal(t, report.OutcomeSkippedCache, p.Outcome)
+	require.Equal(t, map[string]int{"company/terraform:fresh:default": 1, "company/terraform:new:default": 1}, cache.reads)
+
+	// Outside a run, every read goes to the cache
+	d.stopRunCache()
+	_, err = d.checkProject(ctx, projects[0], true)
+	require.NoError(t, err)
+	require.Equal(t, 2, cache.reads["company/terraform:fresh:default"])
+}
@@ -0,0 +1,73 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"go.uber.org/zap"
+)
+
+// Retry controls how temporary Atlantis errors are retried within a run
+type Retry struct {
+	// Attempts is how many times a plan is tried in total.  Zero or one means no retries.
+	Attempts int
+	// InitialBackoff is the wait before the first retry.  Each retry after that waits twice as long.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration
+}
+
+// backoff is how long to wait before retry number attempt (starting at 1), with jitter so that parallel workers
+// hitting the same overloaded Atlantis don't retry in lockstep.  The result is between half and all of the
+// exponential backoff.
+func (r Retry) backoff(attempt int) time.Duration {
+	d := r.InitialBackoff
+	for i := 1; i < attempt && (r.MaxBackoff <= 0 || d < r.MaxBackoff); i++ {
+		d *= 2
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+func isTemporary(err error) bool {
+	var tmp atlantis.TemporaryError
+	return errors.As(err, &tmp) && tmp.Temporary()
+}
+
+// planWithRetry requests a plan, retrying temporary errors with backoff.  The last error is returned once the
+// attempts run out.
+func (d *Drifter) planWithRetry(ctx context.Context, req *atlantis.PlanSummaryRequest) (*atlantis.PlanResult, error) {
+	for attempt := 1; ; attempt++ {
+		pr, err := d.AtlantisClient.PlanSummary(ctx, req)
+		if err == nil || !isTemporary(err) || attempt >= d.Retry.Attempts {
+			return pr, err
+		}
+		wait := d.Retry.backoff(attempt)
+		d.Logger.Warn("Temporary error, retrying", zap.String("dir", req.Dir), zap.String("workspace", req.Workspace), zap.String("project", req.Project), zap.Int("attempt", attempt), zap.Duration("backoff", wait), zap.Error(err))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// cacheTTL is how long a cached result is trusted.  A failed check is retried sooner than a successful one.
+func (d *Drifter) cacheTTL(v *processedcache.DriftCheckValue) time.Duration {
+	if v.Error != "" {
+		return d.ErrorCacheDuration
+	}
+	return d.CacheValidDuration
+}
@@ -0,0 +1,227 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/notification"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+const noChangesResponse = `{"ProjectResults": [{"PlanSuccess": {"TerraformOutput": "No changes. Your infrastructure matches the configuration."}}]}`
+
+// fakeAtlantis answers the first failures plan requests with an unparsable 503, then with no changes
+func fakeAtlantis(t *testing.T, failures int32) (*atlantis.Client, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("upstream busy"))
+			return
+		}
+		_, _ = w.Write([]byte(noChangesResponse))
+	}))
+	t.Cleanup(srv.Close)
+	return &atlantis.Client{AtlantisHostname: srv.URL, HTTPClient: srv.Client()}, &calls
+}
+
+type temporaryErrorNotification struct {
+	MockNotification
+	errs []error
+}
+
+func (n *temporaryErrorNotification) TemporaryError(_ context.Context, _ notification.Location, err error) error {
+	n.errs = append(n.errs, err)
+	return nil
+}
+
+type storingCache struct {
+	processedcache.Noop
+	previous *processedcache.DriftCheckValue
+	stored   *processedcache.DriftCheckValue
+	stores   int
+}
+
+func (s *storingCache) GetDriftCheckResult(_ context.Context, _ *processedcache.ConsiderDriftChecked) (*processedcache.DriftCheckValue, error) {
+	return s.previous, nil
+}
+
+func (s *storingCache) StoreDriftCheckResult(_ context.Context, _ *processedcache.ConsiderDriftChecked, value *processedcache.DriftCheckValue) error {
+	s.stored = value
+	s.stores++
+	return nil
+}
+
+func TestRetry_Backoff(t *testing.T) {
+	r := Retry{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		for i := 0; i < 20; i++ {
+			b := r.backoff(attempt)
+			require.GreaterOrEqual(t, b, max/2)
+			require.LessOrEqual(t, b, max)
+		}
+	}
+	require.Zero(t, Retry{}.backoff(1))
+}
+
+func TestDrifter_CheckProjectRetriesTemporaryErrors(t *testing.T) {
+	client, calls := fakeAtlantis(t, 2)
+	cache := &storingCache{}
+	d := &Drifter{
+		Logger:         zaptest.NewLogger(t),
+		AtlantisClient: client,
+		ResultCache:    cache,
+		Notification:   &temporaryErrorNotification{},
+		Retry:          Retry{Attempts: 3, InitialBackoff: time.Millisecond},
+	}
+	p, err := d.checkProject(context.Background(), atlantis.Project{Dir: "infra/prod", Workspace: "default"}, false)
+	require.NoError(t, err)
+	require.Equal(t, report.OutcomeClean, p.Outcome)
+	require.Equal(t, int32(3), calls.Load())
+	require.Empty(t, cache.stored.Error)
+}
+
+func TestDrifter_CheckProjectNotifiesTemporaryError(t *testing.T) {
+	client, calls := fakeAtlantis(t, 10)
+	cache := &storingCache{}
+	notif := &temporaryErrorNotification{}
+	d := &Drifter{
+		Logger:             zaptest.NewLogger(t),
+		AtlantisClient:     client,
+		ResultCache:        cache,
+		Notification:       notif,
+		Retry:              Retry{Attempts: 2, InitialBackoff: time.Millisecond},
+		CacheValidDuration: 24 * time.Hour,
+		ErrorCacheDuration: time.Hour,
+	}
+	p, err := d.checkProject(context.Background(), atlantis.Project{Dir: "infra/prod", Workspace: "default"}, false)
+	require.NoError(t, err)
+	require.Equal(t, report.OutcomeTemporaryError, p.Outcome)
+	require.Equal(t, int32(2), calls.Load())
+	require.Len(t, notif.errs, 1)
+	require.NotEmpty(t, cache.stored.Error)
+	require.Equal(t, time.Hour, d.cacheTTL(cache.stored))
+}
+
+func TestDrifter_PlanWithRetryStopsWhenCancelled(t *testing.T) {
+	client, _ := fakeAtlantis(t, 10)
+	d := &Drifter{
+		Logger:         zaptest.NewLogger(t),
+		AtlantisClient: client,
+		Retry:          Retry{Attempts: 5, InitialBackoff: time.Hour},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := d.planWithRetry(ctx, &atlantis.PlanSummaryRequest{Dir: "infra/prod", Workspace: "default"})
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestDrifter_ProjectTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "infra/slow") {
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte(noChangesResponse))
+	}))
+	t.Cleanup(srv.Close)
+	cache := &storingCache{}
+	d := &Drifter{
+		Logger:         zaptest.NewLogger(t),
+		AtlantisClient: &atlantis.Client{AtlantisHostname: srv.URL, HTTPClient: srv.Client()},
+		ResultCache:    cache,
+		Notification:   &temporaryErrorNotification{},
+		Retry:          Retry{Attempts: 3, InitialBackoff: time.Millisecond},
+		ProjectTimeout: 50 * time.Millisecond,
+	}
+	rep := report.New(d.Repo)
+	err := d.FindDriftedWorkspaces(context.Background(), atlantis.Projects{
+		{Dir: "infra/slow", Workspace: "default"},
+		{Dir: "infra/slow", Workspace: "prod"},
+		{Dir: "infra/fast", Workspace: "default"},
+	}, RunOptions{Report: rep})
+	require.NoError(t, err)
+	require.Equal(t, map[report.Outcome]int{report.OutcomeTimedOut: 2, report.OutcomeClean: 1}, rep.Counts())
+	require.Contains(t, rep.Failures()[0].Error, "context deadline exceeded")
+	// Only the project that finished is cached, so timed out projects are checked again next run
+	require.Equal(t, 1, cache.stores)
+	require.Empty(t, cache.stored.Error)
+	require.Empty(t, d.Notification.(*temporaryErrorNotification).errs)
+}
+
+// mapCache is a result cache that deletes and overwrites entries like a real one
+type mapCache struct {
+	processedcache.Noop
+	results map[string]*processedcache.DriftCheckValue
+}
+
+func (m *mapCache) GetDriftCheckResult(_ context.Context, key *processedcache.ConsiderDriftChecked) (*processedcache.DriftCheckValue, error) {
+	return m.results[key.String()], nil
+}
+
+func (m *mapCache) StoreDriftCheckResult(_ context.Context, key *processedcache.ConsiderDriftChecked, value *processedcache.DriftCheckValue) error {
+	m.results[key.String()] = value
+	return nil
+}
+
+func (m *mapCache) DeleteDriftCheckResult(_ context.Context, key *processedcache.ConsiderDriftChecked) error {
+	delete(m.results, key.String())
+	return nil
+}
+
+func TestDrifter_TimeoutKeepsDriftForResolution(t *testing.T) {
+	var slow atomic.Bool
+	slow.Store(true)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		_, _ = w.Write([]byte(noChangesResponse))
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	firstDetected := time.Now().Add(-72 * time.Hour)
+	cache := &mapCache{results: map[string]*processedcache.DriftCheckValue{
+		"company/terraform:infra/prod:default": {When: time.Now().Add(-48 * time.Hour), Drift: true, FirstDetected: firstDetected, Detections: 3},
+	}}
+	notif := &MockNotification{}
+	d := &Drifter{
+		Logger:             zaptest.NewLogger(t),
+		Repo:               "company/terraform",
+		AtlantisClient:     &atlantis.Client{AtlantisHostname: srv.URL, HTTPClient: srv.Client()},
+		ResultCache:        cache,
+		Notification:       notif,
+		CacheValidDuration: 24 * time.Hour,
+		ProjectTimeout:     50 * time.Millisecond,
+	}
+	projects := atlantis.Projects{{Dir: "infra/prod", Workspace: "default"}}
+	rep := report.New(d.Repo)
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), projects, RunOptions{Report: rep}))
+	require.Equal(t, 1, rep.Count(report.OutcomeTimedOut))
+	kept := cache.results["company/terraform:infra/prod:default"]
+	require.True(t, kept.Drift)
+	require.Equal(t, 3, kept.Detections)
+
+	slow.Store(false)
+	rep = report.New(d.Repo)
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), projects, RunOptions{Report: rep}))
+	require.Equal(t, 1, rep.Count(report.OutcomeClean))
+	require.True(t, notif.DriftResolvedCalled)
+}
@@ -0,0 +1,83 @@
+package drifter
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+)
+
+// Shard splits the projects of every repo between Count instances, so several deployments or CI jobs can share a
+// sweep without coordinating.  Each (dir, workspace) hashes to one shard, and Index is the shard this instance checks,
+// counting from 0.  A Count of 0 or 1 checks everything.
+type Shard struct {
+	Index int
+	Count int
+}
+
+func (s Shard) enabled() bool {
+	return s.Count > 1
+}
+
+func (s Shard) String() string {
+	if !s.enabled() {
+		return ""
+	}
+	return fmt.Sprintf("%d-of-%d", s.Index, s.Count)
+}
+
+// ReportPath is where this shard writes its report, so shards sharing a volume do not overwrite each other
+func (s Shard) ReportPath(path string) string {
+	if path == "" || !s.enabled() {
+		return path
+	}
+	return path + "-shard-" + s.String()
+}
+
+func (s Shard) owns(parts ...string) bool {
+	if !s.enabled() {
+		return true
+	}
+	h := fnv.New32a()
+	// The separator keeps ("a/b", "c") and ("a", "b/c") apart
+	_, _ = h.Write([]byte(strings.Join(parts, "\x00")))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index
+}
+
+// ownsProject is true if this shard checks the project.  Projects sharing a directory and workspace share a shard.
+func (s Shard) ownsProject(p atlantis.Project) bool {
+	return s.owns(p.Dir, p.Workspace)
+}
+
+// ownsDirectory is true if this shard checks the workspaces of dir.  It compares against every project in the
+// directory, not just those this shard plans, so it hashes the directory alone.
+func (s Shard) ownsDirectory(dir string) bool {
+	return s.owns(dir)
+}
+
+func (s Shard) projects(projects atlantis.Projects) atlantis.Projects {
+	if !s.enabled() {
+		return projects
+	}
+	var ret atlantis.Projects
+	for _, p := range projects {
+		if s.ownsProject(p) {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+func (s Shard) workspaces(ws atlantis.DirectoriesWithWorkspaces) atlantis.DirectoriesWithWorkspaces {
+	if !s.enabled() {
+		return ws
+	}
+	ret := make(atlantis.DirectoriesWithWorkspaces)
+	for dir, workspaces := range ws {
+		if s.ownsDirectory(dir) {
+			ret[dir] = workspaces
+		}
+	}
+	return ret
+}
@@ -0,0 +1,66 @@
+package drifter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShard_Projects(t *testing.T) {
+	var projects atlantis.Projects
+	for i := 0; i < 100; i++ {
+		dir := fmt.Sprintf("infra/%d", i)
+		projects = append(projects,
+			atlantis.Project{Name: dir + "-a", Dir: dir, Workspace: "default"},
+			atlantis.Project{Name: dir + "-b", Dir: dir, Workspace: "default"},
+			atlantis.Project{Dir: dir, Workspace: "prod"},
+		)
+	}
+	owners := make(map[string]int)
+	for i := 0; i < 3; i++ {
+		shard := Shard{Index: i, Count: 3}
+		owned := shard.projects(projects)
+		// Every shard gets a fair share
+		require.Greater(t, len(owned), 50)
+		for _, p := range owned {
+			key := p.Dir + "#" + p.Workspace
+			if owner, exists := owners[key]; exists {
+				// Projects sharing a directory and workspace share a shard
+				require.Equal(t, i, owner, key)
+			}
+			owners[key] = i
+		}
+	}
+	require.Len(t, owners, 200)
+
+	require.Equal(t, projects, Shard{}.projects(projects))
+	require.Equal(t, projects, Shard{Count: 1}.projects(projects))
+}
+
+func TestShard_Workspaces(t *testing.T) {
+	ws := atlantis.DirectoriesWithWorkspaces{}
+	for i := 0; i < 20; i++ {
+		ws[fmt.Sprintf("infra/%d", i)] = []string{"default", "prod"}
+	}
+	seen := 0
+	for i := 0; i < 2; i++ {
+		owned := Shard{Index: i, Count: 2}.workspaces(ws)
+		for dir, workspaces := range owned {
+			// Each directory is compared against all of its workspaces, not only the ones its shard plans
+			require.Equal(t, ws[dir], workspaces)
+		}
+		seen += len(owned)
+	}
+	require.Equal(t, len(ws), seen)
+}
+
+func TestShard_Names(t *testing.T) {
+	shard := Shard{Index: 1, Count: 4}
+	require.Equal(t, "reports/drift-shard-1-of-4", shard.ReportPath("reports/drift"))
+	require.Equal(t, "reports/drift", Shard{}.ReportPath("reports/drift"))
+	require.Equal(t, "", shard.ReportPath(""))
+	require.Equal(t, "drift-run:company/terraform:shard-1-of-4", (&Drifter{Repo: "company/terraform", Shard: shard}).runLeaseName())
+	require.Equal(t, "drift-run:company/terraform", (&Drifter{Repo: "company/terraform"}).runLeaseName())
+}
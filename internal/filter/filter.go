@@ -0,0 +1,266 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+)
+
+// regexPrefix marks a pattern as a regular expression instead of a glob
+const regexPrefix = "re:"
+
+// Rule matches projects by directory, workspace and Atlantis project name.  Each pattern is a doublestar glob, such as
+// "infra/**/sandbox", or a regular expression prefixed with "re:", which must match the whole value.  Every pattern
+// that is set must match.
+type Rule struct {
+	Dir       string `yaml:"dir"`
+	Workspace string `yaml:"workspace"`
+	Project   string `yaml:"project"`
+}
+
+// String is the rule in the same form ParseRules reads it
+func (r Rule) String() string {
+	var parts []string
+	for _, f := range r.fields() {
+		if f.pattern != "" {
+			parts = append(parts, f.name+"="+escaper.Replace(f.pattern))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+type field struct {
+	name    string
+	pattern string
+	value   func(p atlantis.Project) string
+}
+
+func (r Rule) fields() []field {
+	return []field{
+		{name: "dir", pattern: r.Dir, value: func(p atlantis.Project) string { return p.Dir }},
+		{name: "workspace", pattern: r.Workspace, value: func(p atlantis.Project) string { return p.Workspace }},
+		{name: "project", pattern: r.Project, value: func(p atlantis.Project) string { return p.Name }},
+	}
+}
+
+// Validate checks that the rule has at least one pattern and that every pattern parses
+func (r Rule) Validate() error {
+	_, err := compile(r)
+	return err
+}
+
+// escaper escapes the separators ParseRules and ParsePriorities split on
+var escaper = strings.NewReplacer(",", `\,`, ";", `\;`)
+
+// splitEscaped splits s on sep, except where sep follows a backslash.  The backslash before sep is dropped, and every
+// other backslash is kept, so regular expressions like `\d` are unchanged.
+func splitEscaped(s string, sep byte) []string {
+	var ret []string
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == sep:
+			b.WriteByte(sep)
+			i++
+		case s[i] == sep:
+			ret = append(ret, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return append(ret, b.String())
+}
+
+// ParseRules parses rules separated by ";".  Each rule is a "," separated list of dir=, workspace= or project=
+// patterns.  A pattern without a key matches the directory.  For example: "**/sandbox/**;workspace=scratch-*".  A
+// pattern that contains "," or ";", such as the regex "re:a{1,3}", escapes them with a backslash: "re:a{1\,3}".
+func ParseRules(s string) ([]Rule, error) {
+	var ret []Rule
+	for _, entry := range splitEscaped(s, ';') {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		r, err := parseRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// parseRule parses a single rule, already split from the others, whose "," separators may still be escaped
+func parseRule(entry string) (Rule, error) {
+	var r Rule
+	for _, part := range splitEscaped(entry, ',') {
+		key, pattern, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			key, pattern = "dir", key
+		}
+		switch key {
+		case "dir":
+			r.Dir = pattern
+		case "workspace":
+			r.Workspace = pattern
+		case "project":
+			r.Project = pattern
+		default:
+			return Rule{}, fmt.Errorf("invalid rule %q: unknown key %q, expected dir, workspace or project", entry, key)
+		}
+	}
+	if err := r.Validate(); err != nil {
+		return Rule{}, err
+	}
+	return r, nil
+}
+
+type matcher struct {
+	field field
+	match func(string) bool
+}
+
+type compiledRule struct {
+	Rule
+	matchers []matcher
+}
+
+func compile(r Rule) (*compiledRule, error) {
+	ret := &compiledRule{Rule: r}
+	for _, f := range r.fields() {
+		if f.pattern == "" {
+			continue
+		}
+		if expr, ok := strings.CutPrefix(f.pattern, regexPrefix); ok {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s regex %q: %w", f.name, expr, err)
+			}
+			ret.matchers = append(ret.matchers, matcher{field: f, match: re.MatchString})
+			continue
+		}
+		if !doublestar.ValidatePattern(f.pattern) {
+			return nil, fmt.Errorf("invalid %s glob %q", f.name, f.pattern)
+		}
+		pattern := f.pattern
+		ret.matchers = append(ret.matchers, matcher{field: f, match: func(s string) bool {
+			return doublestar.MatchUnvalidated(pattern, s)
+		}})
+	}
+	if len(ret.matchers) == 0 {
+		return nil, fmt.Errorf("rule needs at least one of dir, workspace or project")
+	}
+	return ret, nil
+}
+
+func (c *compiledRule) matches(p atlantis.Project) bool {
+	for _, m := range c.matchers {
+		if !m.match(m.field.value(p)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesDirectory is true if the rule's dir pattern, if it has one, matches dir.  Other patterns are ignored.
+func (c *compiledRule) matchesDirectory(dir string) bool {
+	for _, m := range c.matchers {
+		if m.field.name == "dir" && !m.match(dir) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter decides which projects are checked.  A nil Filter checks everything.
+type Filter struct {
+	directoryWhitelist []string
+	include            []*compiledRule
+	exclude            []*compiledRule
+}
+
+// New builds a filter.  whitelist, if set, limits checks to exactly these directories.  Projects matching any exclude
+// rule are skipped, and if include is set, so are projects that match none of its rules.
+func New(whitelist []string, include []Rule, exclude []Rule) (*Filter, error) {
+	ret := &Filter{directoryWhitelist: whitelist}
+	for _, r := range include {
+		c, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("include rule %q: %w", r, err)
+		}
+		ret.include = append(ret.include, c)
+	}
+	for _, r := range exclude {
+		c, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("exclude rule %q: %w", r, err)
+		}
+		ret.exclude = append(ret.exclude, c)
+	}
+	return ret, nil
+}
+
+// Check returns whether the project should be checked, and the reason: the rule that skipped or included it.  The
+// reason is empty when no rules apply.  Exclude rules take precedence over include rules.
+func (f *Filter) Check(p atlantis.Project) (bool, string) {
+	if f == nil {
+		return true, ""
+	}
+	if !f.whitelisted(p.Dir) {
+		return false, "not in the directory whitelist"
+	}
+	for _, r := range f.exclude {
+		if r.matches(p) {
+			return false, fmt.Sprintf("excluded by rule %s", r)
+		}
+	}
+	if len(f.include) == 0 {
+		return true, ""
+	}
+	for _, r := range f.include {
+		if r.matches(p) {
+			return true, fmt.Sprintf("included by rule %s", r)
+		}
+	}
+	return false, "not matched by any include rule"
+}
+
+// CheckDirectory is Check for a whole directory.  Only exclude rules with nothing but a dir pattern can skip a
+// directory, and include rules only need their dir pattern, if any, to match.
+func (f *Filter) CheckDirectory(dir string) (bool, string) {
+	if f == nil {
+		return true, ""
+	}
+	if !f.whitelisted(dir) {
+		return false, "not in the directory whitelist"
+	}
+	for _, r := range f.exclude {
+		if r.Workspace == "" && r.Project == "" && r.matchesDirectory(dir) {
+			return false, fmt.Sprintf("excluded by rule %s", r)
+		}
+	}
+	if len(f.include) == 0 {
+		return true, ""
+	}
+	for _, r := range f.include {
+		if r.matchesDirectory(dir) {
+			return true, fmt.Sprintf("included by rule %s", r)
+		}
+	}
+	return false, "not matched by any include rule"
+}
+
+func (f *Filter) whitelisted(dir string) bool {
+	if len(f.directoryWhitelist) == 0 {
+		return true
+	}
+	for _, w := range f.directoryWhitelist {
+		if dir == w {
+			return true
+		}
+	}
+	return false
+}
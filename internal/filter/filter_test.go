@@ -0,0 +1,90 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Check(t *testing.T) {
+	f, err := New(nil, []Rule{{Dir: "infra/terraform/**"}}, []Rule{
+		{Dir: "**/sandbox/**"},
+		{Dir: "*/scratch"},
+		{Workspace: "scratch-*"},
+		{Project: "re:.*-(tmp|old)"},
+	})
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		project atlantis.Project
+		checked bool
+		reason  string
+	}{
+		{atlantis.Project{Dir: "infra/terraform/prod/db", Workspace: "default"}, true, "included by rule dir=infra/terraform/**"},
+		{atlantis.Project{Dir: "infra/terraform/sandbox/db", Workspace: "default"}, false, "excluded by rule dir=**/sandbox/**"},
+		{atlantis.Project{Dir: "infra/scratch", Workspace: "default"}, false, "excluded by rule dir=*/scratch"},
+		{atlantis.Project{Dir: "infra/terraform/prod/db", Workspace: "scratch-alice"}, false, "excluded by rule workspace=scratch-*"},
+		{atlantis.Project{Name: "db-old", Dir: "infra/terraform/prod/db", Workspace: "default"}, false, "excluded by rule project=re:.*-(tmp|old)"},
+		{atlantis.Project{Name: "db-older", Dir: "infra/terraform/prod/db", Workspace: "default"}, true, "included by rule dir=infra/terraform/**"},
+		{atlantis.Project{Dir: "environments/prod", Workspace: "default"}, false, "not matched by any include rule"},
+	} {
+		checked, reason := f.Check(tc.project)
+		require.Equal(t, tc.checked, checked, tc.project)
+		require.Equal(t, tc.reason, reason, tc.project)
+	}
+}
+
+func TestFilter_CheckWhitelist(t *testing.T) {
+	f, err := New([]string{"infra/prod"}, nil, nil)
+	require.NoError(t, err)
+	checked, _ := f.Check(atlantis.Project{Dir: "infra/prod"})
+	require.True(t, checked)
+	checked, reason := f.Check(atlantis.Project{Dir: "infra/prod/db"})
+	require.False(t, checked)
+	require.Equal(t, "not in the directory whitelist", reason)
+
+	var nilFilter *Filter
+	checked, _ = nilFilter.Check(atlantis.Project{Dir: "infra/prod/db"})
+	require.True(t, checked)
+}
+
+func TestFilter_CheckDirectory(t *testing.T) {
+	f, err := New(nil, []Rule{{Dir: "infra/**", Workspace: "prod"}}, []Rule{{Dir: "infra/sandbox"}, {Workspace: "scratch"}})
+	require.NoError(t, err)
+	checked, _ := f.CheckDirectory("infra/prod")
+	require.True(t, checked)
+	checked, reason := f.CheckDirectory("infra/sandbox")
+	require.False(t, checked)
+	require.Equal(t, "excluded by rule dir=infra/sandbox", reason)
+	checked, _ = f.CheckDirectory("environments/prod")
+	require.False(t, checked)
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("**/sandbox/** ; workspace=scratch-*,dir=infra/**;project=re:.*-tmp")
+	require.NoError(t, err)
+	require.Equal(t, []Rule{
+		{Dir: "**/sandbox/**"},
+		{Dir: "infra/**", Workspace: "scratch-*"},
+		{Project: "re:.*-tmp"},
+	}, rules)
+
+	rules, err = ParseRules(`project=re:a{1\,3}\;b,workspace=re:\d+;dir=infra/**`)
+	require.NoError(t, err)
+	require.Equal(t, []Rule{
+		{Project: "re:a{1,3};b", Workspace: `re:\d+`},
+		{Dir: "infra/**"},
+	}, rules)
+	require.Equal(t, `workspace=re:\d+,project=re:a{1\,3}\;b`, rules[0].String())
+	reparsed, err := ParseRules(rules[0].String())
+	require.NoError(t, err)
+	require.Equal(t, rules[:1], reparsed)
+
+	_, err = ParseRules("name=prod")
+	require.ErrorContains(t, err, `unknown key "name"`)
+	_, err = ParseRules("project=re:(")
+	require.ErrorContains(t, err, "invalid project regex")
+	_, err = ParseRules("dir=infra/[")
+	require.ErrorContains(t, err, "invalid dir glob")
+	require.Error(t, Rule{}.Validate())
+}
@@ -0,0 +1,86 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+)
+
+// Priority weighs the projects its rule matches.  Projects with a higher weight are checked first, and projects no
+// priority matches weigh 0.
+type Priority struct {
+	Rule   `yaml:",inline"`
+	Weight int `yaml:"weight"`
+}
+
+// String is the priority in the same form ParsePriorities reads it
+func (p Priority) String() string {
+	return fmt.Sprintf("%d:%s", p.Weight, p.Rule)
+}
+
+// ParsePriorities parses priorities separated by ";".  Each is a weight, a ":" and a rule as ParseRules reads it,
+// including its escapes.  For example: "10:infra/prod/**;5:workspace=prod"
+func ParsePriorities(s string) ([]Priority, error) {
+	var ret []Priority
+	for _, entry := range splitEscaped(s, ';') {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		weight, rule, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid priority %q: expected <weight>:<rule>", entry)
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(weight))
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority %q: weight %q is not a number", entry, weight)
+		}
+		if strings.TrimSpace(rule) == "" {
+			return nil, fmt.Errorf("invalid priority %q: expected a rule after the weight", entry)
+		}
+		r, err := parseRule(strings.TrimSpace(rule))
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority %q: %w", entry, err)
+		}
+		ret = append(ret, Priority{Rule: r, Weight: w})
+	}
+	return ret, nil
+}
+
+type weightedRule struct {
+	*compiledRule
+	weight int
+}
+
+// Priorities weighs projects.  A nil Priorities weighs every project 0.
+type Priorities struct {
+	rules []weightedRule
+}
+
+// NewPriorities compiles priorities
+func NewPriorities(priorities []Priority) (*Priorities, error) {
+	ret := &Priorities{}
+	for _, p := range priorities {
+		c, err := compile(p.Rule)
+		if err != nil {
+			return nil, fmt.Errorf("priority %q: %w", p, err)
+		}
+		ret.rules = append(ret.rules, weightedRule{compiledRule: c, weight: p.Weight})
+	}
+	return ret, nil
+}
+
+// Weight is the highest weight of the priorities matching the project, or 0 if none do
+func (p *Priorities) Weight(project atlantis.Project) int {
+	if p == nil {
+		return 0
+	}
+	weight, matched := 0, false
+	for _, r := range p.rules {
+		if r.matches(project) && (!matched || r.weight > weight) {
+			weight, matched = r.weight, true
+		}
+	}
+	return weight
+}
@@ -0,0 +1,52 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorities_Weight(t *testing.T) {
+	p, err := NewPriorities([]Priority{
+		{Rule: Rule{Dir: "infra/prod/**"}, Weight: 10},
+		{Rule: Rule{Workspace: "prod"}, Weight: 5},
+		{Rule: Rule{Dir: "**/sandbox/**"}, Weight: -5},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 10, p.Weight(atlantis.Project{Dir: "infra/prod/db", Workspace: "prod"}))
+	require.Equal(t, 5, p.Weight(atlantis.Project{Dir: "infra/shared", Workspace: "prod"}))
+	require.Equal(t, -5, p.Weight(atlantis.Project{Dir: "infra/sandbox/db", Workspace: "default"}))
+	require.Equal(t, 0, p.Weight(atlantis.Project{Dir: "infra/shared", Workspace: "default"}))
+
+	var nilPriorities *Priorities
+	require.Equal(t, 0, nilPriorities.Weight(atlantis.Project{Dir: "infra/prod/db"}))
+
+	_, err = NewPriorities([]Priority{{Weight: 1}})
+	require.Error(t, err)
+}
+
+func TestParsePriorities(t *testing.T) {
+	priorities, err := ParsePriorities("10:infra/prod/** ; 5:workspace=prod,dir=infra/**;-1:project=re:.*-tmp")
+	require.NoError(t, err)
+	require.Equal(t, []Priority{
+		{Rule: Rule{Dir: "infra/prod/**"}, Weight: 10},
+		{Rule: Rule{Dir: "infra/**", Workspace: "prod"}, Weight: 5},
+		{Rule: Rule{Project: "re:.*-tmp"}, Weight: -1},
+	}, priorities)
+	require.Equal(t, "5:dir=infra/**,workspace=prod", priorities[1].String())
+
+	priorities, err = ParsePriorities(`10:project=re:prod-\d{1\,3}\;x;5:infra/**`)
+	require.NoError(t, err)
+	require.Equal(t, []Priority{
+		{Rule: Rule{Project: `re:prod-\d{1,3};x`}, Weight: 10},
+		{Rule: Rule{Dir: "infra/**"}, Weight: 5},
+	}, priorities)
+
+	_, err = ParsePriorities("infra/prod/**")
+	require.ErrorContains(t, err, "expected <weight>:<rule>")
+	_, err = ParsePriorities("high:infra/prod/**")
+	require.ErrorContains(t, err, "is not a number")
+	_, err = ParsePriorities("10:")
+	require.ErrorContains(t, err, "expected a rule after the weight")
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "atlantis_drift"
+
+// Metrics holds every collector this service exports.  All methods are safe to call on a nil *Metrics, which
+// records nothing.
+type Metrics struct {
+	runDuration          *prometheus.HistogramVec
+	projectsChecked      *prometheus.CounterVec
+	projectDrifted       *prometheus.GaugeVec
+	cacheResults         *prometheus.CounterVec
+	atlantisPlanDuration *prometheus.HistogramVec
+	notificationFailures *prometheus.CounterVec
+	atlantisConcurrency  prometheus.Gauge
+}
+
+// New creates the collectors and registers them with reg
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "run_duration_seconds",
+			Help:      "How long a drift run took, by repo and whether it succeeded",
+			Buckets:   prometheus.ExponentialBuckets(10, 2, 10),
+		}, []string{"repo", "result"}),
+		projectsChecked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "projects_checked_total",
+			Help:      "Projects checked, by repo and outcome",
+		}, []string{"repo", "outcome"}),
+		projectDrifted: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "project_drifted",
+			Help:      "1 if the project drifted the last time it was checked, otherwise 0",
+		}, []string{"repo", "dir", "workspace", "project"}),
+		cacheResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_lookups_total",
+			Help:      "Result cache lookups, by whether a still valid result was found",
+		}, []string{"result"}),
+		atlantisPlanDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "atlantis_plan_duration_seconds",
+			Help:      "Latency of Atlantis plan requests, by HTTP status code",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+		}, []string{"code"}),
+		notificationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "notification_failures_total",
+			Help:      "Notifications that failed to send, by notifier",
+		}, []string{"notifier"}),
+		atlantisConcurrency: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "atlantis_concurrency_limit",
+			Help:      "How many plans may be requested from Atlantis at once, when adaptive concurrency is on",
+		}),
+	}
+	reg.MustRegister(m.runDuration, m.projectsChecked, m.projectDrifted, m.cacheResults, m.atlantisPlanDuration, m.notificationFailures, m.atlantisConcurrency)
+	return m
+}
+
+func (m *Metrics) ObserveRun(repo string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.runDuration.WithLabelValues(repo, result).Observe(duration.Seconds())
+}
+
+func (m *Metrics) ProjectChecked(repo string, outcome string) {
+	if m == nil {
+		return
+	}
+	m.projectsChecked.WithLabelValues(repo, outcome).Inc()
+}
+
+// SetProjectDrifted records whether a project drifted.  project is its Atlantis name, or empty for an unnamed project.
+func (m *Metrics) SetProjectDrifted(repo string, dir string, workspace string, project string, drifted bool) {
+	if m == nil {
+		return
+	}
+	value := 0.0
+	if drifted {
+		value = 1
+	}
+	m.projectDrifted.WithLabelValues(repo, dir, workspace, project).Set(value)
+}
+
+func (m *Metrics) CacheHit() {
+	if m == nil {
+		return
+	}
+	m.cacheResults.WithLabelValues("hit").Inc()
+}
+
+func (m *Metrics) CacheMiss() {
+	if m == nil {
+		return
+	}
+	m.cacheResults.WithLabelValues("miss").Inc()
+}
+
+// ObserveAtlantisPlan records a plan request.  statusCode is 0 if no response was received.
+func (m *Metrics) ObserveAtlantisPlan(statusCode int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	code := "error"
+	if statusCode != 0 {
+		code = strconv.Itoa(statusCode)
+	}
+	m.atlantisPlanDuration.WithLabelValues(code).Observe(duration.Seconds())
+}
+
+func (m *Metrics) NotificationFailed(notifier string) {
+	if m == nil {
+		return
+	}
+	m.notificationFailures.WithLabelValues(notifier).Inc()
+}
+
+func (m *Metrics) SetAtlantisConcurrencyLimit(limit int) {
+	if m == nil {
+		return
+	}
+	m.atlantisConcurrency.Set(float64(limit))
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_Nil(t *testing.T) {
+	var m *Metrics
+	m.ObserveRun("repo", time.Second, nil)
+	m.ProjectChecked("repo", "clean")
+	m.SetProjectDrifted("repo", "dir", "default", "", true)
+	m.CacheHit()
+	m.CacheMiss()
+	m.ObserveAtlantisPlan(200, time.Second)
+	m.NotificationFailed("SlackWebhook")
+}
+
+func TestMetrics_Record(t *testing.T) {
+	m := New(prometheus.NewRegistry())
+	m.ProjectChecked("repo", "drifted")
+	m.ProjectChecked("repo", "drifted")
+	m.SetProjectDrifted("repo", "dir", "default", "network", true)
+	// Projects sharing a directory and workspace keep their own value
+	m.SetProjectDrifted("repo", "dir", "default", "database", false)
+	m.CacheHit()
+	m.CacheMiss()
+	m.CacheMiss()
+	m.ObserveRun("repo", time.Second, errors.New("failed"))
+	m.ObserveAtlantisPlan(0, time.Second)
+	m.NotificationFailed("SlackWebhook")
+
+	require.Equal(t, 2.0, testutil.ToFloat64(m.projectsChecked.WithLabelValues("repo", "drifted")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.projectDrifted.WithLabelValues("repo", "dir", "default", "network")))
+	require.Equal(t, 0.0, testutil.ToFloat64(m.projectDrifted.WithLabelValues("repo", "dir", "default", "database")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.cacheResults.WithLabelValues("hit")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.cacheResults.WithLabelValues("miss")))
+	require.Equal(t, 1, testutil.CollectAndCount(m.runDuration, "atlantis_drift_run_duration_seconds"))
+	require.Equal(t, 1, testutil.CollectAndCount(m.atlantisPlanDuration, "atlantis_drift_atlantis_plan_duration_seconds"))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.notificationFailures.WithLabelValues("SlackWebhook")))
+}
@@ -0,0 +1,179 @@
+package notification
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+)
+
+// maxDigestDirectories and maxFailures keep a message well under Slack's size limit
+const (
+	maxDigestDirectories = 50
+	maxFailures          = 20
+	maxFailureLength     = 300
+)
+
+type digestCounts struct {
+	clean, drifted, locked, errored, skipped, resolved int
+	// projects that need attention, or had their drift resolved, by outcome
+	driftedProjects, lockedProjects, erroredProjects, resolvedProjects []string
+}
+
+// projectLabel names a project within its directory: its Atlantis name if it has one, otherwise its workspace
+func projectLabel(p report.Project) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.Workspace
+}
+
+func (c *digestCounts) add(p report.Project) {
+	if p.Resolved {
+		c.resolved++
+		c.resolvedProjects = append(c.resolvedProjects, projectLabel(p))
+	}
+	switch p.Outcome {
+	case report.OutcomeClean:
+		c.clean++
+	case report.OutcomeDrifted:
+		c.drifted++
+		c.driftedProjects = append(c.driftedProjects, projectLabel(p))
+	case report.OutcomeLocked:
+		c.locked++
+		c.lockedProjects = append(c.lockedProjects, projectLabel(p))
+	case report.OutcomeFailed, report.OutcomeTimedOut, report.OutcomeTemporaryError:
+		c.errored++
+		c.erroredProjects = append(c.erroredProjects, projectLabel(p))
+	case report.OutcomeSkippedCache, report.OutcomeSkippedFilter:
+		c.skipped++
+	}
+}
+
+func (c *digestCounts) needsAttention() bool {
+	return c.drifted+c.locked+c.errored > 0
+}
+
+// digestNews is true if the digest of rep would list anything beyond counts: drifted, locked, errored or resolved
+// projects, or workspaces that do not match the Atlantis config
+func digestNews(rep *report.Report) bool {
+	var total digestCounts
+	for _, p := range rep.SortedProjects() {
+		total.add(p)
+	}
+	return total.needsAttention() || total.resolved > 0 || formatWorkspaceProblems(rep) != ""
+}
+
+// FormatDigest summarizes a run in one Slack message, listing each directory with drifted, locked, errored or resolved
+// projects
+func FormatDigest(rep *report.Report, runErr error) string {
+	var total digestCounts
+	byDir := make(map[string]*digestCounts)
+	for _, p := range rep.SortedProjects() {
+		total.add(p)
+		if byDir[p.Dir] == nil {
+			byDir[p.Dir] = &digestCounts{}
+		}
+		byDir[p.Dir].add(p)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*Drift detection for %s* finished in %s\n", rep.Repo, rep.Finished.Sub(rep.Started).Round(time.Second))
+	fmt.Fprintf(&b, "%d drifted, %d clean, %d locked, %d errored, %d skipped\n", total.drifted, total.clean, total.locked, total.errored, total.skipped)
+	dirs := make([]string, 0, len(byDir))
+	for dir, c := range byDir {
+		if c.needsAttention() || c.resolved > 0 {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	if !total.needsAttention() && runErr == nil {
+		b.WriteString("No drift found :white_check_mark:\n")
+	}
+	for i, dir := range dirs {
+		if i == maxDigestDirectories {
+			fmt.Fprintf(&b, "...and %d more directories\n", len(dirs)-i)
+			break
+		}
+		c := byDir[dir]
+		var parts []string
+		if c.drifted > 0 {
+			parts = append(parts, fmt.Sprintf("%d drifted (%s)", c.drifted, strings.Join(c.driftedProjects, ", ")))
+		}
+		if c.locked > 0 {
+			parts = append(parts, fmt.Sprintf("%d locked (%s)", c.locked, strings.Join(c.lockedProjects, ", ")))
+		}
+		if c.errored > 0 {
+			parts = append(parts, fmt.Sprintf("%d errored (%s)", c.errored, strings.Join(c.erroredProjects, ", ")))
+		}
+		if c.resolved > 0 {
+			parts = append(parts, fmt.Sprintf("%d resolved (%s)", c.resolved, strings.Join(c.resolvedProjects, ", ")))
+		}
+		if c.clean > 0 {
+			parts = append(parts, fmt.Sprintf("%d clean", c.clean))
+		}
+		fmt.Fprintf(&b, "• `%s`: %s\n", dir, strings.Join(parts, ", "))
+	}
+	b.WriteString(formatWorkspaceProblems(rep))
+	b.WriteString(FormatFailures(rep))
+	if runErr != nil {
+		fmt.Fprintf(&b, ":warning: The run stopped early: %s\n", runErr)
+	}
+	return b.String()
+}
+
+// formatWorkspaceProblems lists the directories whose workspaces do not match the Atlantis config, or returns "" if
+// all of them do
+func formatWorkspaceProblems(rep *report.Report) string {
+	var b strings.Builder
+	for _, c := range rep.SortedWorkspaceChecks() {
+		if c.Outcome == report.WorkspaceOutcomeOK {
+			continue
+		}
+		var parts []string
+		if len(c.Extra) > 0 {
+			parts = append(parts, fmt.Sprintf("extra workspaces (%s)", strings.Join(c.Extra, ", ")))
+		}
+		if len(c.Missing) > 0 {
+			parts = append(parts, fmt.Sprintf("missing workspaces (%s)", strings.Join(c.Missing, ", ")))
+		}
+		if c.Error != "" {
+			msg := c.Error
+			if len(msg) > maxFailureLength {
+				msg = msg[:maxFailureLength] + "..."
+			}
+			parts = append(parts, fmt.Sprintf("%s: %s", c.Outcome, msg))
+		}
+		if len(parts) == 0 {
+			parts = append(parts, string(c.Outcome))
+		}
+		fmt.Fprintf(&b, "• `%s`: %s\n", c.Dir, strings.Join(parts, ", "))
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "*Workspace checks*\n" + b.String()
+}
+
+// FormatFailures lists the projects that failed with their errors, or returns "" if none did
+func FormatFailures(rep *report.Report) string {
+	failures := rep.Failures()
+	if len(failures) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d failed in %s*\n", len(failures), rep.Repo)
+	for i, p := range failures {
+		if i == maxFailures {
+			fmt.Fprintf(&b, "...and %d more\n", len(failures)-i)
+			break
+		}
+		msg := p.Error
+		if len(msg) > maxFailureLength {
+			msg = msg[:maxFailureLength] + "..."
+		}
+		fmt.Fprintf(&b, "• `%s` (%s): %s\n", p.Dir, projectLabel(p), msg)
+	}
+	return b.String()
+}
@@ -0,0 +1,147 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+	"github.com/stretchr/testify/require"
+)
+
+func digestReport() *report.Report {
+	rep := report.New("company/terraform")
+	rep.Add(report.Project{Dir: "infra/prod", Workspace: "us-east-1", Outcome: report.OutcomeDrifted})
+	rep.Add(report.Project{Dir: "infra/prod", Workspace: "eu-west-1", Outcome: report.OutcomeDrifted})
+	rep.Add(report.Project{Dir: "infra/prod", Workspace: "default", Outcome: report.OutcomeClean})
+	rep.Add(report.Project{Name: "shared-network", Dir: "infra/shared", Workspace: "default", Outcome: report.OutcomeTemporaryError})
+	rep.Add(report.Project{Dir: "infra/sandbox", Workspace: "default", Outcome: report.OutcomeClean})
+	rep.Finished = rep.Started.Add(90 * time.Second)
+	return rep
+}
+
+func TestFormatDigest(t *testing.T) {
+	msg := FormatDigest(digestReport(), nil)
+	require.Contains(t, msg, "*Drift detection for company/terraform* finished in 1m30s\n")
+	require.Contains(t, msg, "2 drifted, 2 clean, 0 locked, 1 errored, 0 skipped\n")
+	require.Contains(t, msg, "• `infra/prod`: 2 drifted (eu-west-1, us-east-1), 1 clean\n")
+	require.Contains(t, msg, "• `infra/shared`: 1 errored (shared-network)\n")
+	require.NotContains(t, msg, "infra/sandbox")
+
+	rep := report.New("company/terraform")
+	rep.Add(report.Project{Name: "network", Dir: "infra/staging", Workspace: "default", Outcome: report.OutcomeClean, Resolved: true})
+	msg = FormatDigest(rep, nil)
+	require.Contains(t, msg, "• `infra/staging`: 1 resolved (network), 1 clean\n")
+	require.Contains(t, msg, "No drift found")
+
+	rep = digestReport()
+	rep.AddWorkspaceCheck(report.WorkspaceCheck{Repo: "company/terraform", Dir: "infra/prod", Outcome: report.WorkspaceOutcomeOK})
+	rep.AddWorkspaceCheck(report.WorkspaceCheck{Repo: "company/terraform", Dir: "infra/staging", Outcome: report.WorkspaceOutcomeExtra, Extra: []string{"old"}})
+	rep.AddWorkspaceCheck(report.WorkspaceCheck{Repo: "company/terraform", Dir: "infra/shared", Outcome: report.WorkspaceOutcomeBackendAuth, Error: "no credentials"})
+	msg = FormatDigest(rep, nil)
+	require.Contains(t, msg, "*Workspace checks*\n• `infra/shared`: backend_auth: no credentials\n• `infra/staging`: extra workspaces (old)\n")
+	require.NotContains(t, msg, "• `infra/prod`: ok")
+
+	msg = FormatDigest(report.New("company/terraform"), errors.New("failed to checkout repo"))
+	require.Contains(t, msg, "The run stopped early: failed to checkout repo")
+}
+
+func TestSlackWebhook_Digest(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+	}))
+	defer srv.Close()
+	wh := NewSlackWebhook(srv.URL, srv.Client())
+	wh.Digest = true
+	ctx := context.Background()
+	require.NoError(t, wh.PlanDrift(ctx, Location{Directory: "infra/prod", Workspace: "us-east-1"}))
+	require.NoError(t, wh.TemporaryError(ctx, Location{Directory: "infra/shared", Workspace: "default"}, errors.New("503")))
+	require.NoError(t, wh.ExtraWorkspaceInRemote(ctx, "infra/staging", "old"))
+	require.NoError(t, wh.MissingWorkspaceInRemote(ctx, "infra/staging", "new"))
+	require.Empty(t, bodies)
+	require.NoError(t, wh.RunCompleted(ctx, digestReport(), nil))
+	require.Len(t, bodies, 1)
+	require.Contains(t, bodies[0], "infra/prod")
+}
+
+func TestSlackWebhook_DigestSkipsQuietBatches(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+	}))
+	defer srv.Close()
+	wh := NewSlackWebhook(srv.URL, srv.Client())
+	wh.Digest = true
+	ctx := context.Background()
+	rep := report.New("company/terraform")
+	rep.SetBatch()
+	rep.Add(report.Project{Dir: "infra/sandbox", Workspace: "default", Outcome: report.OutcomeClean})
+	require.NoError(t, wh.RunCompleted(ctx, rep, nil))
+	require.Empty(t, bodies)
+
+	rep.AddWorkspaceCheck(report.WorkspaceCheck{Repo: "company/terraform", Dir: "infra/sandbox", Outcome: report.WorkspaceOutcomeMissing, Missing: []string{"staging"}})
+	require.NoError(t, wh.RunCompleted(ctx, rep, nil))
+	require.Len(t, bodies, 1)
+	require.Contains(t, bodies[0], "missing workspaces (staging)")
+
+	rep = report.New("company/terraform")
+	rep.SetBatch()
+	rep.Add(report.Project{Dir: "infra/sandbox", Workspace: "default", Outcome: report.OutcomeClean, Resolved: true})
+	require.NoError(t, wh.RunCompleted(ctx, rep, nil))
+	require.Len(t, bodies, 2)
+
+	// A full run always posts, so a quiet digest still shows the run happened
+	require.NoError(t, wh.RunCompleted(ctx, report.New("company/terraform"), nil))
+	require.Len(t, bodies, 3)
+}
+
+func TestSlackWebhook_RunCompletedListsFailures(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+	}))
+	defer srv.Close()
+	wh := NewSlackWebhook(srv.URL, srv.Client())
+	ctx := context.Background()
+	require.NoError(t, wh.RunCompleted(ctx, digestReport(), nil))
+	require.Empty(t, bodies)
+
+	rep := digestReport()
+	rep.Add(report.Project{Dir: "infra/broken", Workspace: "default", Outcome: report.OutcomeFailed, Error: "project result unknown failure"})
+	require.NoError(t, wh.RunCompleted(ctx, rep, nil))
+	require.Len(t, bodies, 1)
+	require.Contains(t, bodies[0], "infra/broken")
+	require.Contains(t, bodies[0], "project result unknown failure")
+}
+
+func TestSlackWebhook_NamesProject(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+	}))
+	defer srv.Close()
+	wh := NewSlackWebhook(srv.URL, srv.Client())
+	require.NoError(t, wh.TemporaryError(context.Background(), Location{Directory: "infra/shared", Workspace: "default", Project: "shared-network"}, errors.New("503")))
+	require.Len(t, bodies, 1)
+	require.Contains(t, bodies[0], `Project: shared-network`)
+}
+
+func TestLocation_DriftAge(t *testing.T) {
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	loc := Location{Directory: "infra/prod", Workspace: "default", DriftingSince: now.Add(-9*24*time.Hour - time.Hour), Detections: 5}
+	require.Equal(t, "Drifted for 9 days, found by 5 checks in a row", loc.driftAge(now))
+	loc.DriftingSince = now.Add(-30 * time.Hour)
+	require.Equal(t, "Drifted for 30 hours, found by 5 checks in a row", loc.driftAge(now))
+	loc.Detections = 1
+	require.Equal(t, "", loc.driftAge(now))
+	require.Equal(t, "", Location{Directory: "infra/prod"}.driftAge(now))
+}
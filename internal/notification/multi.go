@@ -1,15 +1,35 @@
 package notification
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cresta/atlantis-drift-detection/internal/metrics"
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+)
 
 type Multi struct {
 	Notifications []Notification
+	Metrics       *metrics.Metrics
+}
+
+// failed records a failed notification and passes the error through
+func (m *Multi) failed(n Notification, err error) error {
+	m.Metrics.NotificationFailed(notifierName(n))
+	return err
 }
 
-func (m *Multi) TemporaryError(ctx context.Context, dir string, workspace string, err error) error {
+// notifierName is the type name of a notification, for example "SlackWebhook"
+func notifierName(n Notification) string {
+	name := fmt.Sprintf("%T", n)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func (m *Multi) TemporaryError(ctx context.Context, loc Location, err error) error {
 	for _, n := range m.Notifications {
-		if err := n.TemporaryError(ctx, dir, workspace, err); err != nil {
-			return err
+		if err := n.TemporaryError(ctx, loc, err); err != nil {
+			return m.failed(n, err)
 		}
 	}
 	return nil
@@ -18,7 +38,7 @@ func (m *Multi) TemporaryError(ctx context.Context, dir string, workspace string
 func (m *Multi) ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
 	for _, n := range m.Notifications {
 		if err := n.ExtraWorkspaceInRemote(ctx, dir, workspace); err != nil {
-			return err
+			return m.failed(n, err)
 		}
 	}
 	return nil
@@ -27,16 +47,43 @@ func (m *Multi) ExtraWorkspaceInRemote(ctx context.Context, dir string, workspac
 func (m *Multi) MissingWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
 	for _, n := range m.Notifications {
 		if err := n.MissingWorkspaceInRemote(ctx, dir, workspace); err != nil {
-			return err
+			return m.failed(n, err)
+		}
+	}
+	return nil
+}
+
+func (m *Multi) PlanDrift(ctx context.Context, loc Location, terraformOutput ...string) error {
+	for _, n := range m.Notifications {
+		if err := n.PlanDrift(ctx, loc, terraformOutput...); err != nil {
+			return m.failed(n, err)
+		}
+	}
+	return nil
+}
+
+func (m *Multi) DriftResolved(ctx context.Context, loc Location) error {
+	for _, n := range m.Notifications {
+		if err := n.DriftResolved(ctx, loc); err != nil {
+			return m.failed(n, err)
+		}
+	}
+	return nil
+}
+
+func (m *Multi) RunStarted(ctx context.Context, repo string) error {
+	for _, n := range m.Notifications {
+		if err := n.RunStarted(ctx, repo); err != nil {
+			return m.failed(n, err)
 		}
 	}
 	return nil
 }
 
-func (m *Multi) PlanDrift(ctx context.Context, dir string, workspace string, terraformOutput ...string) error {
+func (m *Multi) RunCompleted(ctx context.Context, rep *report.Report, runErr error) error {
 	for _, n := range m.Notifications {
-		if err := n.PlanDrift(ctx, dir, workspace, terraformOutput...); err != nil {
-			return err
+		if err := n.RunCompleted(ctx, rep, runErr); err != nil {
+			return m.failed(n, err)
 		}
 	}
 	return nil
@@ -2,6 +2,10 @@ package notification
 
 import (
 	"context"
+	"fmt"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/report"
 )
 
 type State int
@@ -13,16 +17,64 @@ const (
 	StateMissingWorkspaceInRemote
 )
 
+// Location identifies the Atlantis project a notification is about
 type Location struct {
 	Directory string
 	Workspace string
+	// Project is the Atlantis project name, or empty for an unnamed project
+	Project string
+	// DriftingSince is when the drift was first found, and Detections how many checks in a row have found it.  They
+	// are only set for PlanDrift.
+	DriftingSince time.Time
+	Detections    int
+}
+
+// describe lists the location one field per line, for plain text messages
+func (l Location) describe() string {
+	ret := fmt.Sprintf("Directory: %s\nWorkspace: %s", l.Directory, l.Workspace)
+	if l.Project != "" {
+		ret += "\nProject: " + l.Project
+	}
+	if age := l.driftAge(time.Now()); age != "" {
+		ret += "\n" + age
+	}
+	return ret
+}
+
+// driftAge says how long the project has been drifted, or returns "" for drift found for the first time
+func (l Location) driftAge(now time.Time) string {
+	if l.DriftingSince.IsZero() || l.Detections <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("Drifted for %s, found by %d checks in a row", formatAge(now.Sub(l.DriftingSince)), l.Detections)
+}
+
+// formatAge rounds d to whole days, or whole hours when it is under two days
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%d days", int(d/(24*time.Hour)))
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", int(d/time.Hour))
+	case d >= time.Hour:
+		return "1 hour"
+	default:
+		return "less than an hour"
+	}
 }
 
 type Notification interface {
 	ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error
 	MissingWorkspaceInRemote(ctx context.Context, dir string, workspace string) error
 	// PlanDrift is called when drift is detected. If terraformOutput is provided, it will be included in the notification
-	PlanDrift(ctx context.Context, dir string, workspace string, terraformOutput ...string) error
+	PlanDrift(ctx context.Context, loc Location, terraformOutput ...string) error
+	// DriftResolved is called when a project that had drifted when it was last checked plans clean
+	DriftResolved(ctx context.Context, loc Location) error
 	// TemporaryError is called when an error occurs but we can't really tell what it means
-	TemporaryError(ctx context.Context, dir string, workspace string, err error) error
+	TemporaryError(ctx context.Context, loc Location, err error) error
+	// RunStarted is called before a repo is checked
+	RunStarted(ctx context.Context, repo string) error
+	// RunCompleted is called once a repo has been checked, with the outcome of every project.  runErr is set if the
+	// run stopped early.
+	RunCompleted(ctx context.Context, rep *report.Report, runErr error) error
 }
@@ -6,15 +6,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/report"
 )
 
 type SlackWebhook struct {
 	WebhookURL string
 	HTTPClient *http.Client
+	// Digest posts one summary when a run completes, instead of a message for each drifted or failed workspace
+	Digest bool
 }
 
-func (s *SlackWebhook) TemporaryError(ctx context.Context, dir string, workspace string, err error) error {
-	return s.sendSlackMessage(ctx, fmt.Sprintf("Unknown error in remote\nDirectory: %s\nWorkspace: %s\nError: %s", dir, workspace, err.Error()))
+func (s *SlackWebhook) TemporaryError(ctx context.Context, loc Location, err error) error {
+	if s.Digest {
+		return nil
+	}
+	return s.sendSlackMessage(ctx, fmt.Sprintf("Unknown error in remote\n%s\nError: %s", loc.describe(), err.Error()))
 }
 
 func NewSlackWebhook(webhookURL string, HTTPClient *http.Client) *SlackWebhook {
@@ -48,21 +56,41 @@ func (s *SlackWebhook) sendSlackMessage(ctx context.Context, msg string) error {
 	if err != nil {
 		return fmt.Errorf("failed to send slack webhook request: %w", err)
 	}
+	_ = resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to send slack webhook request: %w", err)
+		return fmt.Errorf("failed to send slack webhook request: unexpected status %s", resp.Status)
 	}
 	return nil
 }
 
+// ExtraWorkspaceInRemote posts the workspace unless in digest mode, where the digest lists workspace checks
 func (s *SlackWebhook) ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
+	if s.Digest {
+		return nil
+	}
 	return s.sendSlackMessage(ctx, fmt.Sprintf("Extra workspace in remote\nDirectory: %s\nWorkspace: %s", dir, workspace))
 }
 
 func (s *SlackWebhook) MissingWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
+	if s.Digest {
+		return nil
+	}
 	return s.sendSlackMessage(ctx, fmt.Sprintf("Missing workspace in remote\nDirectory: %s\nWorkspace: %s", dir, workspace))
 }
 
-func (s *SlackWebhook) PlanDrift(ctx context.Context, dir string, workspace string, terraformOutput ...string) error {
+func (s *SlackWebhook) PlanDrift(ctx context.Context, loc Location, terraformOutput ...string) error {
+	if s.Digest {
+		return nil
+	}
+	dir := loc.Directory
+	// The formatter only knows about directories, so name the project above its message
+	var prefix string
+	if loc.Project != "" {
+		prefix = fmt.Sprintf("Project: `%s`\n", loc.Project)
+	}
+	if age := loc.driftAge(time.Now()); age != "" {
+		prefix += age + "\n"
+	}
 	// Comment out existing implementation
 	// return s.sendSlackMessage(ctx, fmt.Sprintf("Plan Drift workspace in remote\nDirectory: %s\nWorkspace: %s", dir, workspace))
 
@@ -74,18 +102,45 @@ func (s *SlackWebhook) PlanDrift(ctx context.Context, dir string, workspace stri
 		message, err := formatter.FormatPlanDriftMessageWithDetails(dir, terraformOutput[0])
 		if err != nil {
 			fmt.Printf("failed to format plan drift message with details: %v\n", err)
-			return s.sendSlackMessage(ctx, fmt.Sprintf("Terraform Plan Drift\nDirectory: %s\nWorkspace: %s", dir, workspace))
+			return s.sendSlackMessage(ctx, "Terraform Plan Drift\n"+loc.describe())
 		}
-		return s.sendSlackMessage(ctx, message)
+		return s.sendSlackMessage(ctx, prefix+message)
 	} else {
 		// Use the basic formatter without drift details
 		message, err := formatter.FormatPlanDriftMessage(dir)
 		if err != nil {
 			fmt.Printf("failed to format plan drift message: %v\n", err)
-			return s.sendSlackMessage(ctx, fmt.Sprintf("Terraform Plan Drift\nDirectory: %s\nWorkspace: %s", dir, workspace))
+			return s.sendSlackMessage(ctx, "Terraform Plan Drift\n"+loc.describe())
 		}
-		return s.sendSlackMessage(ctx, message)
+		return s.sendSlackMessage(ctx, prefix+message)
 	}
 }
 
+// DriftResolved posts a follow up to an earlier drift message.  In digest mode the digest lists resolved projects.
+func (s *SlackWebhook) DriftResolved(ctx context.Context, loc Location) error {
+	if s.Digest {
+		return nil
+	}
+	return s.sendSlackMessage(ctx, ":white_check_mark: Drift resolved\n"+loc.describe())
+}
+
+func (s *SlackWebhook) RunStarted(_ context.Context, _ string) error {
+	return nil
+}
+
+// RunCompleted posts the digest if enabled, skipping batches with nothing to report so frequent small runs stay
+// quiet.  Otherwise drift was already posted as it was found, so only failures are listed.
+func (s *SlackWebhook) RunCompleted(ctx context.Context, rep *report.Report, runErr error) error {
+	if s.Digest {
+		if rep.Batch && runErr == nil && !digestNews(rep) {
+			return nil
+		}
+		return s.sendSlackMessage(ctx, FormatDigest(rep, runErr))
+	}
+	if msg := FormatFailures(rep); msg != "" {
+		return s.sendSlackMessage(ctx, msg)
+	}
+	return nil
+}
+
 var _ Notification = &SlackWebhook{}
@@ -34,7 +34,7 @@ func TestSlackWebhook_PlanDriftWithTerraformOutput(t *testing.T) {
 Plan: 0 to add, 1 to change, 0 to destroy.`
 
 	// This test will fail if the webhook URL is not valid, but we can test the logic
-	err := wh.PlanDrift(context.Background(), "infra/terraform/database/prod/us-east-1/production/redis", "workspace", terraformOutput)
+	err := wh.PlanDrift(context.Background(), Location{Directory: "infra/terraform/database/prod/us-east-1/production/redis", Workspace: "workspace"}, terraformOutput)
 
 	// We expect an error because the webhook URL is not real, but the error should be about the HTTP request, not about formatting
 	if err != nil {
@@ -50,7 +50,7 @@ func TestSlackWebhook_PlanDriftWithoutTerraformOutput(t *testing.T) {
 	wh := NewSlackWebhook("https://hooks.slack.com/test", mockClient)
 
 	// Test without Terraform output (empty variadic parameter)
-	err := wh.PlanDrift(context.Background(), "infra/terraform/database/staging/myproject", "workspace")
+	err := wh.PlanDrift(context.Background(), Location{Directory: "infra/terraform/database/staging/myproject", Workspace: "workspace"})
 
 	// We expect an error because the webhook URL is not real, but the error should be about the HTTP request, not about formatting
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"sync"
 
+	"github.com/cresta/atlantis-drift-detection/internal/report"
 	"github.com/cresta/gogithub"
 )
 
@@ -31,7 +32,7 @@ type Workflow struct {
 	directoriesDone map[string]struct{}
 }
 
-func (w *Workflow) TemporaryError(_ context.Context, _ string, _ string, _ error) error {
+func (w *Workflow) TemporaryError(_ context.Context, _ Location, _ error) error {
 	// Ignored
 	return nil
 }
@@ -44,7 +45,20 @@ func (w *Workflow) MissingWorkspaceInRemote(_ context.Context, _ string, _ strin
 	return nil
 }
 
-func (w *Workflow) PlanDrift(ctx context.Context, dir string, _ string, _ ...string) error {
+func (w *Workflow) DriftResolved(_ context.Context, _ Location) error {
+	return nil
+}
+
+func (w *Workflow) RunStarted(_ context.Context, _ string) error {
+	return nil
+}
+
+func (w *Workflow) RunCompleted(_ context.Context, _ *report.Report, _ error) error {
+	return nil
+}
+
+func (w *Workflow) PlanDrift(ctx context.Context, loc Location, _ ...string) error {
+	dir := loc.Directory
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	if w.directoriesDone == nil {
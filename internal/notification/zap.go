@@ -3,6 +3,7 @@ package notification
 import (
 	"context"
 
+	"github.com/cresta/atlantis-drift-detection/internal/report"
 	"go.uber.org/zap"
 )
 
@@ -10,20 +11,29 @@ type Zap struct {
 	Logger *zap.Logger
 }
 
-func (I *Zap) TemporaryError(_ context.Context, dir string, workspace string, err error) error {
-	I.Logger.Error("Unknown error in remote", zap.String("dir", dir), zap.String("workspace", workspace), zap.Error(err))
+func (I *Zap) TemporaryError(_ context.Context, loc Location, err error) error {
+	I.Logger.Error("Unknown error in remote", zap.String("dir", loc.Directory), zap.String("workspace", loc.Workspace), zap.String("project", loc.Project), zap.Error(err))
 	return nil
 }
 
-func (I *Zap) PlanDrift(_ context.Context, dir string, workspace string, terraformOutput ...string) error {
+func (I *Zap) PlanDrift(_ context.Context, loc Location, terraformOutput ...string) error {
+	fields := []zap.Field{
+		zap.String("dir", loc.Directory),
+		zap.String("workspace", loc.Workspace),
+		zap.String("project", loc.Project),
+	}
+	if !loc.DriftingSince.IsZero() {
+		fields = append(fields, zap.Time("drifting_since", loc.DriftingSince), zap.Int("detections", loc.Detections))
+	}
 	if len(terraformOutput) > 0 && terraformOutput[0] != "" {
-		I.Logger.Info("Plan has drifted",
-			zap.String("dir", dir),
-			zap.String("workspace", workspace),
-			zap.String("terraform_output", terraformOutput[0]))
-	} else {
-		I.Logger.Info("Plan has drifted", zap.String("dir", dir), zap.String("workspace", workspace))
+		fields = append(fields, zap.String("terraform_output", terraformOutput[0]))
 	}
+	I.Logger.Info("Plan has drifted", fields...)
+	return nil
+}
+
+func (I *Zap) DriftResolved(_ context.Context, loc Location) error {
+	I.Logger.Info("Drift resolved", zap.String("dir", loc.Directory), zap.String("workspace", loc.Workspace), zap.String("project", loc.Project))
 	return nil
 }
 
@@ -37,4 +47,22 @@ func (I *Zap) MissingWorkspaceInRemote(_ context.Context, dir string, workspace
 	return nil
 }
 
+func (I *Zap) RunStarted(_ context.Context, repo string) error {
+	I.Logger.Info("Drift run started", zap.String("repo", repo))
+	return nil
+}
+
+func (I *Zap) RunCompleted(_ context.Context, rep *report.Report, runErr error) error {
+	for _, p := range rep.Failures() {
+		I.Logger.Error("Project failed", zap.String("repo", rep.Repo), zap.String("dir", p.Dir), zap.String("workspace", p.Workspace), zap.String("project", p.Name), zap.String("error", p.Error))
+	}
+	fields := []zap.Field{zap.String("repo", rep.Repo), zap.Any("outcomes", rep.Counts())}
+	if runErr != nil {
+		I.Logger.Warn("Drift run stopped early", append(fields, zap.Error(runErr))...)
+		return nil
+	}
+	I.Logger.Info("Drift run completed", fields...)
+	return nil
+}
+
 var _ Notification = &Zap{}
@@ -7,32 +7,83 @@ import (
 )
 
 type ConsiderDriftChecked struct {
+	// The repo checked, so several repos can share a cache
+	Repo string
 	// The directory checked
 	Dir string
 	// The workspace checked
 	Workspace string
+	// The Atlantis project name, if the project has one.  Projects can share a directory and workspace.
+	Project string
 }
 
+// String is the cache key.  Without a repo it is the form used before keys had one.
 func (d *ConsiderDriftChecked) String() string {
-	return fmt.Sprintf("%s:%s", d.Dir, d.Workspace)
+	if d.Repo == "" {
+		if d.Project != "" {
+			return fmt.Sprintf("%s:%s:%s", d.Dir, d.Workspace, d.Project)
+		}
+		return fmt.Sprintf("%s:%s", d.Dir, d.Workspace)
+	}
+	if d.Project != "" {
+		return fmt.Sprintf("%s:%s:%s:%s", d.Repo, d.Dir, d.Workspace, d.Project)
+	}
+	return fmt.Sprintf("%s:%s:%s", d.Repo, d.Dir, d.Workspace)
 }
 
 type DriftCheckValue struct {
 	// If non-empty, indicates an error in the checking
 	Error string
-	// Only if we have an empty error: the result of checking for drift
+	// The result of checking for drift.  A check that errored, or found the project locked, keeps the previous result
+	// so that resolving drift is noticed once the project plans again.
 	Drift bool `json:"drift"`
 	// Only if we have an empty error: when we did this check
 	When time.Time
+	// FirstDetected is when the current run of drift was first found, or zero if the project is not drifted
+	FirstDetected time.Time
+	// LastDetected is when drift was last found, or zero if the project is not drifted
+	LastDetected time.Time
+	// Detections is how many checks in a row have found drift.  Checks that could not tell, such as a locked plan, do
+	// not break the streak.
+	Detections int
+}
+
+// Detected records drift found at when, continuing the streak of previous if it was drifted too
+func (v *DriftCheckValue) Detected(previous *DriftCheckValue, when time.Time) {
+	v.Drift = true
+	v.FirstDetected = when
+	v.LastDetected = when
+	v.Detections = 1
+	if previous != nil && previous.Drift && !previous.FirstDetected.IsZero() {
+		v.FirstDetected = previous.FirstDetected
+		v.Detections = previous.Detections + 1
+	}
+}
+
+// KeepDrift copies the drift history of previous, for checks that could not tell whether the project drifted
+func (v *DriftCheckValue) KeepDrift(previous *DriftCheckValue) {
+	if previous == nil {
+		return
+	}
+	v.Drift = previous.Drift
+	v.FirstDetected = previous.FirstDetected
+	v.LastDetected = previous.LastDetected
+	v.Detections = previous.Detections
 }
 
 type ConsiderWorkspacesChecked struct {
+	// The repo checked, so several repos can share a cache
+	Repo string
 	// Directory checked
 	Dir string
 }
 
+// String is the cache key.  Without a repo it is the form used before keys had one.
 func (d *ConsiderWorkspacesChecked) String() string {
-	return d.Dir
+	if d.Repo == "" {
+		return d.Dir
+	}
+	return fmt.Sprintf("%s:%s", d.Repo, d.Dir)
 }
 
 type WorkspacesCheckedValue struct {
@@ -51,6 +102,15 @@ type ProcessedCache interface {
 	GetRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked) (*WorkspacesCheckedValue, error)
 	StoreRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked, value *WorkspacesCheckedValue) error
 	DeleteRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked) error
+	Leaser
+}
+
+// Ping verifies the cache backend is reachable by reading a key that is not expected to exist
+func Ping(ctx context.Context, cache ProcessedCache) error {
+	if _, err := cache.GetRemoteWorkspaces(ctx, &ConsiderWorkspacesChecked{Dir: "test"}); err != nil {
+		return fmt.Errorf("failed to read from cache: %w", err)
+	}
+	return nil
 }
 
 type Noop struct{}
@@ -79,4 +139,17 @@ func (n Noop) DeleteRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspace
 	return nil
 }
 
+// AcquireLease always succeeds: without a shared cache there is nothing to coordinate with
+func (n Noop) AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (n Noop) RenewLease(ctx context.Context, name string, owner string, ttl time.Duration) error {
+	return nil
+}
+
+func (n Noop) ReleaseLease(ctx context.Context, name string, owner string) error {
+	return nil
+}
+
 var _ ProcessedCache = &Noop{}
@@ -10,6 +10,7 @@ import (
 func GenericCacheWorkflowTest(t *testing.T, cache ProcessedCache) {
 	currentTime := time.Now().Round(time.Millisecond)
 	testKey := &ConsiderDriftChecked{
+		Repo:      "company/terraform",
 		Dir:       "test" + currentTime.String(),
 		Workspace: "test",
 	}
@@ -34,3 +35,67 @@ func GenericCacheWorkflowTest(t *testing.T, cache ProcessedCache) {
 	require.NoError(t, err)
 	require.Nil(t, item)
 }
+
+func GenericLeaseTest(t *testing.T, leaser Leaser) {
+	ctx := context.Background()
+	name := "test-lease" + time.Now().String()
+	acquired, err := leaser.AcquireLease(ctx, name, "first", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	defer func() {
+		require.NoError(t, leaser.ReleaseLease(ctx, name, "first"))
+		require.NoError(t, leaser.ReleaseLease(ctx, name, "second"))
+	}()
+	// Acquiring again as the same owner is allowed
+	acquired, err = leaser.AcquireLease(ctx, name, "first", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	acquired, err = leaser.AcquireLease(ctx, name, "second", time.Minute)
+	require.NoError(t, err)
+	require.False(t, acquired)
+	require.ErrorIs(t, leaser.RenewLease(ctx, name, "second", time.Minute), ErrLeaseLost)
+	require.NoError(t, leaser.RenewLease(ctx, name, "first", time.Minute))
+	// Releasing someone else's lease does nothing
+	require.NoError(t, leaser.ReleaseLease(ctx, name, "second"))
+	acquired, err = leaser.AcquireLease(ctx, name, "second", time.Minute)
+	require.NoError(t, err)
+	require.False(t, acquired)
+	require.NoError(t, leaser.ReleaseLease(ctx, name, "first"))
+	acquired, err = leaser.AcquireLease(ctx, name, "second", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+}
+
+func TestNoop_AcquireLease(t *testing.T) {
+	acquired, err := Noop{}.AcquireLease(context.Background(), "lease", "owner", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+}
+
+func TestConsiderDriftChecked_String(t *testing.T) {
+	require.Equal(t, "company/terraform:infra:default", (&ConsiderDriftChecked{Repo: "company/terraform", Dir: "infra", Workspace: "default"}).String())
+	require.Equal(t, "company/terraform:infra:default:infra-audit", (&ConsiderDriftChecked{Repo: "company/terraform", Dir: "infra", Workspace: "default", Project: "infra-audit"}).String())
+}
+
+func TestDriftCheckValue_Detected(t *testing.T) {
+	first := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	var v DriftCheckValue
+	v.Detected(nil, first)
+	require.Equal(t, DriftCheckValue{Drift: true, FirstDetected: first, LastDetected: first, Detections: 1}, v)
+
+	second := first.Add(24 * time.Hour)
+	locked := DriftCheckValue{When: second}
+	locked.KeepDrift(&v)
+	require.Equal(t, 1, locked.Detections)
+
+	third := second.Add(24 * time.Hour)
+	var again DriftCheckValue
+	again.Detected(&locked, third)
+	require.Equal(t, DriftCheckValue{Drift: true, FirstDetected: first, LastDetected: third, Detections: 2}, again)
+
+	// Drift found again after a clean check starts a new streak
+	var fresh DriftCheckValue
+	fresh.Detected(&DriftCheckValue{When: third}, third)
+	require.Equal(t, 1, fresh.Detections)
+	require.Equal(t, third, fresh.FirstDetected)
+}
@@ -2,7 +2,12 @@ package processedcache
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -23,8 +28,7 @@ func NewDynamoDB(ctx context.Context, table string) (*DynamoDB, error) {
 		Client: dynamodb.NewFromConfig(cfg),
 		Table:  table,
 	}
-	_, err = c.GetRemoteWorkspaces(ctx, &ConsiderWorkspacesChecked{Dir: "test"})
-	if err != nil {
+	if err := Ping(ctx, &c); err != nil {
 		return nil, fmt.Errorf("failed to verify dynamodb cache: %w", err)
 	}
 	return &c, nil
@@ -140,4 +144,79 @@ func (d *DynamoDB) DeleteRemoteWorkspaces(ctx context.Context, key *ConsiderWork
 	return d.genericDelete(ctx, "ConsiderWorkspacesChecked", key)
 }
 
+func leaseExpires(ttl time.Duration) *types.AttributeValueMemberN {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Add(ttl).UnixMilli(), 10)}
+}
+
+func isConditionFailed(err error) bool {
+	var ccf *types.ConditionalCheckFailedException
+	return errors.As(err, &ccf)
+}
+
+// leaseAttributeNames avoids "owner", which is a DynamoDB reserved word
+var leaseAttributeNames = map[string]string{
+	"#owner":   "Owner",
+	"#expires": "Expires",
+}
+
+func (d *DynamoDB) AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	item := dynamoKeyForDriftCheckResultKey("Lease", leaseKey(name))
+	item["Owner"] = &types.AttributeValueMemberS{Value: owner}
+	item["Expires"] = leaseExpires(ttl)
+	_, err := d.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                &d.Table,
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(K) OR #expires < :now OR #owner = :owner"),
+		ExpressionAttributeNames: leaseAttributeNames,
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":   leaseExpires(0),
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to acquire lease %s: %w", name, err)
+	}
+	return true, nil
+}
+
+func (d *DynamoDB) RenewLease(ctx context.Context, name string, owner string, ttl time.Duration) error {
+	_, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                &d.Table,
+		Key:                      dynamoKeyForDriftCheckResultKey("Lease", leaseKey(name)),
+		UpdateExpression:         aws.String("SET #expires = :expires"),
+		ConditionExpression:      aws.String("#owner = :owner"),
+		ExpressionAttributeNames: leaseAttributeNames,
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":expires": leaseExpires(ttl),
+			":owner":   &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return ErrLeaseLost
+		}
+		return fmt.Errorf("failed to renew lease %s: %w", name, err)
+	}
+	return nil
+}
+
+func (d *DynamoDB) ReleaseLease(ctx context.Context, name string, owner string) error {
+	_, err := d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                &d.Table,
+		Key:                      dynamoKeyForDriftCheckResultKey("Lease", leaseKey(name)),
+		ConditionExpression:      aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]string{"#owner": "Owner"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	if err != nil && !isConditionFailed(err) {
+		return fmt.Errorf("failed to release lease %s: %w", name, err)
+	}
+	return nil
+}
+
 var _ ProcessedCache = &DynamoDB{}
@@ -17,3 +17,7 @@ func makeTestClient(t *testing.T) *DynamoDB {
 func TestDynamoDB(t *testing.T) {
 	GenericCacheWorkflowTest(t, makeTestClient(t))
 }
+
+func TestDynamoDB_Lease(t *testing.T) {
+	GenericLeaseTest(t, makeTestClient(t))
+}
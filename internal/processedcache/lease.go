@@ -0,0 +1,28 @@
+package processedcache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLeaseLost is returned when renewing a lease that another owner now holds
+var ErrLeaseLost = errors.New("lease is held by another owner")
+
+// Leaser stores time limited leases, so only one of several instances does a piece of work at a time
+type Leaser interface {
+	// AcquireLease takes the named lease for owner if nobody holds it, it has expired, or owner already holds it.
+	// It returns false if another owner holds an unexpired lease.
+	AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error)
+	// RenewLease extends a lease owner holds, or returns ErrLeaseLost
+	RenewLease(ctx context.Context, name string, owner string, ttl time.Duration) error
+	// ReleaseLease gives up a lease owner holds.  Releasing a lease held by someone else does nothing.
+	ReleaseLease(ctx context.Context, name string, owner string) error
+}
+
+// leaseKey names a lease.  Leases are stored with an Owner and an Expires time in unix milliseconds.
+type leaseKey string
+
+func (l leaseKey) String() string {
+	return string(l)
+}
@@ -0,0 +1,87 @@
+package processedcache
+
+import (
+	"context"
+	"fmt"
+)
+
+// LegacyKeys falls back to entries stored before keys included the repo, so upgrading keeps drift history and does
+// not re-plan everything at once.  Those entries can only belong to Repo, since a deployment checked a single repo
+// then.  New results are always stored under the new keys, and deleting removes both.
+type LegacyKeys struct {
+	ProcessedCache
+	Repo string
+}
+
+func (l *LegacyKeys) legacyDriftKey(key *ConsiderDriftChecked) *ConsiderDriftChecked {
+	// Projects were not part of keys before repos were
+	if key.Repo != l.Repo || key.Project != "" {
+		return nil
+	}
+	return &ConsiderDriftChecked{Dir: key.Dir, Workspace: key.Workspace}
+}
+
+func (l *LegacyKeys) legacyWorkspacesKey(key *ConsiderWorkspacesChecked) *ConsiderWorkspacesChecked {
+	if key.Repo != l.Repo {
+		return nil
+	}
+	return &ConsiderWorkspacesChecked{Dir: key.Dir}
+}
+
+func (l *LegacyKeys) GetDriftCheckResult(ctx context.Context, key *ConsiderDriftChecked) (*DriftCheckValue, error) {
+	ret, err := l.ProcessedCache.GetDriftCheckResult(ctx, key)
+	if err != nil || ret != nil {
+		return ret, err
+	}
+	legacy := l.legacyDriftKey(key)
+	if legacy == nil {
+		return nil, nil
+	}
+	ret, err = l.ProcessedCache.GetDriftCheckResult(ctx, legacy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read legacy key %s: %w", legacy, err)
+	}
+	return ret, nil
+}
+
+func (l *LegacyKeys) DeleteDriftCheckResult(ctx context.Context, key *ConsiderDriftChecked) error {
+	if err := l.ProcessedCache.DeleteDriftCheckResult(ctx, key); err != nil {
+		return err
+	}
+	if legacy := l.legacyDriftKey(key); legacy != nil {
+		if err := l.ProcessedCache.DeleteDriftCheckResult(ctx, legacy); err != nil {
+			return fmt.Errorf("failed to delete legacy key %s: %w", legacy, err)
+		}
+	}
+	return nil
+}
+
+func (l *LegacyKeys) GetRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked) (*WorkspacesCheckedValue, error) {
+	ret, err := l.ProcessedCache.GetRemoteWorkspaces(ctx, key)
+	if err != nil || ret != nil {
+		return ret, err
+	}
+	legacy := l.legacyWorkspacesKey(key)
+	if legacy == nil {
+		return nil, nil
+	}
+	ret, err = l.ProcessedCache.GetRemoteWorkspaces(ctx, legacy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read legacy key %s: %w", legacy, err)
+	}
+	return ret, nil
+}
+
+func (l *LegacyKeys) DeleteRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked) error {
+	if err := l.ProcessedCache.DeleteRemoteWorkspaces(ctx, key); err != nil {
+		return err
+	}
+	if legacy := l.legacyWorkspacesKey(key); legacy != nil {
+		if err := l.ProcessedCache.DeleteRemoteWorkspaces(ctx, legacy); err != nil {
+			return fmt.Errorf("failed to delete legacy key %s: %w", legacy, err)
+		}
+	}
+	return nil
+}
+
+var _ ProcessedCache = &LegacyKeys{}
@@ -0,0 +1,76 @@
+package processedcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryCache stores values by key string, the way DynamoDB does
+type memoryCache struct {
+	Noop
+	drift      map[string]*DriftCheckValue
+	workspaces map[string]*WorkspacesCheckedValue
+}
+
+func (m *memoryCache) GetDriftCheckResult(_ context.Context, key *ConsiderDriftChecked) (*DriftCheckValue, error) {
+	return m.drift[key.String()], nil
+}
+
+func (m *memoryCache) StoreDriftCheckResult(_ context.Context, key *ConsiderDriftChecked, value *DriftCheckValue) error {
+	m.drift[key.String()] = value
+	return nil
+}
+
+func (m *memoryCache) DeleteDriftCheckResult(_ context.Context, key *ConsiderDriftChecked) error {
+	delete(m.drift, key.String())
+	return nil
+}
+
+func (m *memoryCache) GetRemoteWorkspaces(_ context.Context, key *ConsiderWorkspacesChecked) (*WorkspacesCheckedValue, error) {
+	return m.workspaces[key.String()], nil
+}
+
+func (m *memoryCache) DeleteRemoteWorkspaces(_ context.Context, key *ConsiderWorkspacesChecked) error {
+	delete(m.workspaces, key.String())
+	return nil
+}
+
+func TestLegacyKeys(t *testing.T) {
+	ctx := context.Background()
+	when := time.Now().Add(-time.Hour)
+	mem := &memoryCache{
+		// Stored by a version that did not put the repo in keys
+		drift:      map[string]*DriftCheckValue{"infra/prod:default": {Drift: true, When: when}},
+		workspaces: map[string]*WorkspacesCheckedValue{"infra/prod": {Workspaces: []string{"default"}, When: when}},
+	}
+	cache := &LegacyKeys{ProcessedCache: mem, Repo: "company/terraform"}
+	key := &ConsiderDriftChecked{Repo: "company/terraform", Dir: "infra/prod", Workspace: "default"}
+
+	v, err := cache.GetDriftCheckResult(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, &DriftCheckValue{Drift: true, When: when}, v)
+	ws, err := cache.GetRemoteWorkspaces(ctx, &ConsiderWorkspacesChecked{Repo: "company/terraform", Dir: "infra/prod"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"default"}, ws.Workspaces)
+
+	// Other repos and named projects never had legacy entries
+	v, err = cache.GetDriftCheckResult(ctx, &ConsiderDriftChecked{Repo: "company/other", Dir: "infra/prod", Workspace: "default"})
+	require.NoError(t, err)
+	require.Nil(t, v)
+	v, err = cache.GetDriftCheckResult(ctx, &ConsiderDriftChecked{Repo: "company/terraform", Dir: "infra/prod", Workspace: "default", Project: "prod"})
+	require.NoError(t, err)
+	require.Nil(t, v)
+
+	// The new key takes precedence, and deleting removes both
+	require.NoError(t, cache.StoreDriftCheckResult(ctx, key, &DriftCheckValue{When: time.Now()}))
+	v, err = cache.GetDriftCheckResult(ctx, key)
+	require.NoError(t, err)
+	require.False(t, v.Drift)
+	require.NoError(t, cache.DeleteDriftCheckResult(ctx, key))
+	require.Empty(t, mem.drift)
+	require.NoError(t, cache.DeleteRemoteWorkspaces(ctx, &ConsiderWorkspacesChecked{Repo: "company/terraform", Dir: "infra/prod"}))
+	require.Empty(t, mem.workspaces)
+}
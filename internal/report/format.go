@@ -0,0 +1,251 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// outcomeOrder is the order outcomes are listed in summaries
+var outcomeOrder = []Outcome{
+	OutcomeDrifted,
+	OutcomeFailed,
+	OutcomeTimedOut,
+	OutcomeTemporaryError,
+	OutcomeLocked,
+	OutcomeClean,
+	OutcomeSkippedCache,
+	OutcomeSkippedFilter,
+	OutcomePlanned,
+}
+
+// SortedProjects returns the projects ordered by repo, dir, workspace and name, since parallel runs add them in any order
+func (r *Report) SortedProjects() []Project {
+	r.mu.Lock()
+	ret := append([]Project(nil), r.Projects...)
+	r.mu.Unlock()
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Repo != ret[j].Repo {
+			return ret[i].Repo < ret[j].Repo
+		}
+		if ret[i].Dir != ret[j].Dir {
+			return ret[i].Dir < ret[j].Dir
+		}
+		if ret[i].Workspace != ret[j].Workspace {
+			return ret[i].Workspace < ret[j].Workspace
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// SortedWorkspaceChecks returns the workspace checks ordered by repo and dir
+func (r *Report) SortedWorkspaceChecks() []WorkspaceCheck {
+	r.mu.Lock()
+	ret := append([]WorkspaceCheck(nil), r.WorkspaceChecks...)
+	r.mu.Unlock()
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Repo != ret[j].Repo {
+			return ret[i].Repo < ret[j].Repo
+		}
+		return ret[i].Dir < ret[j].Dir
+	})
+	return ret
+}
+
+// WriteTable writes one aligned row per project
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "REPO\tPROJECT\tDIR\tWORKSPACE\tOUTCOME\tREASON"); err != nil {
+		return err
+	}
+	for _, p := range r.SortedProjects() {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Repo, p.Name, p.Dir, p.Workspace, p.Outcome, p.Reason); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+type jsonReport struct {
+	Repo            string           `json:"repo"`
+	Started         time.Time        `json:"started"`
+	Finished        time.Time        `json:"finished"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	Total           int              `json:"total"`
+	Shard           string           `json:"shard,omitempty"`
+	Batch           bool             `json:"batch,omitempty"`
+	Outcomes        map[Outcome]int  `json:"outcomes"`
+	Projects        []Project        `json:"projects"`
+	WorkspaceChecks []WorkspaceCheck `json:"workspace_checks,omitempty"`
+}
+
+// WriteJSON writes the report, with outcome counts, as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	outcomes := r.Counts()
+	projects := r.SortedProjects()
+	workspaceChecks := r.SortedWorkspaceChecks()
+	r.mu.Lock()
+	out := jsonReport{
+		Repo:            r.Repo,
+		Started:         r.Started,
+		Finished:        r.Finished,
+		DurationSeconds: r.Finished.Sub(r.Started).Seconds(),
+		Total:           r.Total,
+		Shard:           r.Shard,
+		Batch:           r.Batch,
+		Outcomes:        outcomes,
+		Projects:        projects,
+		WorkspaceChecks: workspaceChecks,
+	}
+	r.mu.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// WriteMarkdown writes a human readable summary, listing drifted and failed projects before everything else
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	counts := r.Counts()
+	projects := r.SortedProjects()
+	r.mu.Lock()
+	repo, started, finished, total, shard := r.Repo, r.Started, r.Finished, r.Total, r.Shard
+	r.mu.Unlock()
+
+	var b strings.Builder
+	if shard != "" {
+		repo += " (shard " + shard + ")"
+	}
+	fmt.Fprintf(&b, "# Drift report: %s\n\n", repo)
+	fmt.Fprintf(&b, "Started %s and took %s. %d of %d projects reported.\n\n", started.UTC().Format(time.RFC3339), finished.Sub(started).Round(time.Second), len(projects), total)
+	b.WriteString("| Outcome | Projects |\n|---|---|\n")
+	for _, o := range outcomeOrder {
+		if counts[o] > 0 {
+			fmt.Fprintf(&b, "| %s | %d |\n", o, counts[o])
+		}
+	}
+	var drifted, resolved, failed []Project
+	for _, p := range projects {
+		if p.Resolved {
+			resolved = append(resolved, p)
+		}
+		switch p.Outcome {
+		case OutcomeDrifted:
+			drifted = append(drifted, p)
+		case OutcomeFailed, OutcomeTimedOut, OutcomeTemporaryError:
+			failed = append(failed, p)
+		}
+	}
+	if len(drifted) > 0 {
+		b.WriteString("\n## Drifted\n\n| Repo | Project | Dir | Workspace | Changes | First detected | Detections |\n|---|---|---|---|---|---|---|\n")
+		for _, p := range drifted {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s |\n", p.Repo, p.Name, p.Dir, p.Workspace, formatChanges(p), formatFirstDetected(p), formatDetections(p))
+		}
+	}
+	if len(resolved) > 0 {
+		b.WriteString("\n## Resolved\n\n| Repo | Project | Dir | Workspace |\n|---|---|---|---|\n")
+		for _, p := range resolved {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", p.Repo, p.Name, p.Dir, p.Workspace)
+		}
+	}
+	if len(failed) > 0 {
+		b.WriteString("\n## Failed\n\n| Repo | Project | Dir | Workspace | Outcome | Error |\n|---|---|---|---|---|---|\n")
+		for _, p := range failed {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n", p.Repo, p.Name, p.Dir, p.Workspace, p.Outcome, markdownCell(p.Error))
+		}
+	}
+	b.WriteString("\n## All projects\n\n| Repo | Project | Dir | Workspace | Outcome | Changes | Duration | Notes |\n|---|---|---|---|---|---|---|---|\n")
+	for _, p := range projects {
+		notes := p.Reason
+		if p.Error != "" {
+			notes = p.Error
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s | %s |\n", p.Repo, p.Name, p.Dir, p.Workspace, p.Outcome, formatChanges(p), formatDuration(p), markdownCell(notes))
+	}
+	var workspaceProblems []WorkspaceCheck
+	for _, c := range r.SortedWorkspaceChecks() {
+		if c.Outcome != WorkspaceOutcomeOK {
+			workspaceProblems = append(workspaceProblems, c)
+		}
+	}
+	if len(workspaceProblems) > 0 {
+		b.WriteString("\n## Workspace checks\n\n| Repo | Dir | Outcome | Extra workspaces | Missing workspaces | Error |\n|---|---|---|---|---|---|\n")
+		for _, c := range workspaceProblems {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n", c.Repo, c.Dir, c.Outcome, strings.Join(c.Extra, ", "), strings.Join(c.Missing, ", "), markdownCell(c.Error))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteFiles writes the report to path with ".json" and ".md" appended, creating parent directories
+func (r *Report) WriteFiles(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	for ext, write := range map[string]func(io.Writer) error{".json": r.WriteJSON, ".md": r.WriteMarkdown} {
+		if err := writeFile(path+ext, write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create report %s: %w", path, err)
+	}
+	if err := write(f); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write report %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close report %s: %w", path, err)
+	}
+	return nil
+}
+
+func formatChanges(p Project) string {
+	if p.Changes == nil {
+		return ""
+	}
+	ret := fmt.Sprintf("+%d ~%d -%d", p.Changes.Add, p.Changes.Change, p.Changes.Destroy)
+	if p.Changes.Import > 0 {
+		ret += fmt.Sprintf(" (%d to import)", p.Changes.Import)
+	}
+	return ret
+}
+
+func formatFirstDetected(p Project) string {
+	if p.FirstDetected == nil {
+		return ""
+	}
+	return p.FirstDetected.UTC().Format(time.RFC3339)
+}
+
+func formatDetections(p Project) string {
+	if p.Detections == 0 {
+		return ""
+	}
+	return strconv.Itoa(p.Detections)
+}
+
+func formatDuration(p Project) string {
+	if p.Started.IsZero() {
+		return ""
+	}
+	return (time.Duration(p.DurationSeconds * float64(time.Second))).Round(time.Millisecond).String()
+}
+
+// markdownCell keeps free text, such as error messages, inside a single table cell
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", "\\|", "\r\n", " ", "\n", " ").Replace(s)
+}
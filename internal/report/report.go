@@ -0,0 +1,252 @@
+package report
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+)
+
+// Outcome is the result of checking a single project
+type Outcome string
+
+const (
+	OutcomeClean          Outcome = "clean"
+	OutcomeDrifted        Outcome = "drifted"
+	OutcomeLocked         Outcome = "locked"
+	OutcomeTemporaryError Outcome = "temporary_error"
+	// OutcomeFailed means checking the project failed with an error that was not temporary
+	OutcomeFailed Outcome = "failed"
+	// OutcomeTimedOut means checking the project took longer than the project timeout.  It is checked again next run.
+	OutcomeTimedOut Outcome = "timed_out"
+	// OutcomeSkippedCache means the project was checked recently enough that the cached result was used
+	OutcomeSkippedCache Outcome = "skipped_cache"
+	// OutcomeSkippedFilter means the project was excluded by directory filters
+	OutcomeSkippedFilter Outcome = "skipped_filter"
+	// OutcomePlanned means a dry run would have planned the project
+	OutcomePlanned Outcome = "planned"
+)
+
+// Checked is true if the project was actually planned, rather than skipped or listed by a dry run
+func (o Outcome) Checked() bool {
+	switch o {
+	case OutcomeSkippedCache, OutcomeSkippedFilter, OutcomePlanned:
+		return false
+	}
+	return true
+}
+
+// Project is the result of checking one Atlantis project
+type Project struct {
+	Repo string `json:"repo"`
+	// Name is the Atlantis project name, if the project has one
+	Name      string  `json:"name,omitempty"`
+	Dir       string  `json:"dir"`
+	Workspace string  `json:"workspace"`
+	Outcome   Outcome `json:"outcome"`
+	// Reason explains why a project was skipped or planned
+	Reason string `json:"reason,omitempty"`
+	// Error is set if checking the project failed
+	Error string `json:"error,omitempty"`
+	// CachedDrift is set when the project was skipped because of the cache and the cached check found drift
+	CachedDrift bool `json:"cached_drift,omitempty"`
+	// Resolved is set when a project that drifted when it was last checked is now clean
+	Resolved bool `json:"resolved,omitempty"`
+	// FirstDetected and LastDetected are when the current drift was first and last found, and Detections how many
+	// checks in a row found it.  They are set for drifted projects, including ones skipped because of the cache.
+	FirstDetected *time.Time `json:"first_detected,omitempty"`
+	LastDetected  *time.Time `json:"last_detected,omitempty"`
+	Detections    int        `json:"detections,omitempty"`
+	// Changes is what the plan would change, if the project was planned
+	Changes         *atlantis.PlanCounts `json:"changes,omitempty"`
+	Started         time.Time            `json:"started"`
+	DurationSeconds float64              `json:"duration_seconds"`
+}
+
+// WorkspaceOutcome is the result of comparing a directory's workspaces in its backend with the Atlantis config
+type WorkspaceOutcome string
+
+const (
+	WorkspaceOutcomeOK WorkspaceOutcome = "ok"
+	// WorkspaceOutcomeExtra means the backend has workspaces Atlantis does not know about
+	WorkspaceOutcomeExtra WorkspaceOutcome = "extra"
+	// WorkspaceOutcomeMissing means the Atlantis config declares workspaces the backend does not have.  It takes
+	// precedence over WorkspaceOutcomeExtra when both apply.
+	WorkspaceOutcomeMissing WorkspaceOutcome = "missing"
+	// WorkspaceOutcomeBackendAuth means terraform could not authenticate to the directory's backend
+	WorkspaceOutcomeBackendAuth WorkspaceOutcome = "backend_auth"
+	WorkspaceOutcomeFailed      WorkspaceOutcome = "failed"
+)
+
+// WorkspaceCheck is the result of listing the workspaces of one directory
+type WorkspaceCheck struct {
+	Repo    string           `json:"repo"`
+	Dir     string           `json:"dir"`
+	Outcome WorkspaceOutcome `json:"outcome"`
+	// Remote is every workspace in the backend
+	Remote []string `json:"remote,omitempty"`
+	// Extra is the workspaces in the backend that are not in the Atlantis config
+	Extra []string `json:"extra,omitempty"`
+	// Missing is the workspaces in the Atlantis config that are not in the backend
+	Missing []string `json:"missing,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Report collects the results of a single drift run.  It is safe to add projects from multiple goroutines.
+type Report struct {
+	Repo     string    `json:"repo"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Total is how many projects the run expects to check, once known
+	Total    int       `json:"total"`
+	Projects []Project `json:"projects"`
+	// WorkspaceChecks are the directories whose workspaces were listed
+	WorkspaceChecks []WorkspaceCheck `json:"workspace_checks,omitempty"`
+	// Shard, if set, is the share of projects the run checked, such as "0-of-4"
+	Shard string `json:"shard,omitempty"`
+	// Batch is set when the run only checked the projects most due for a check, as trickle mode does
+	Batch bool `json:"batch,omitempty"`
+
+	mu sync.Mutex
+}
+
+func New(repo string) *Report {
+	return &Report{
+		Repo:    repo,
+		Started: time.Now(),
+	}
+}
+
+// ForRepo returns a copy of the report with only the projects of repo
+func (r *Report) ForRepo(repo string) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := &Report{
+		Repo:     repo,
+		Started:  r.Started,
+		Finished: r.Finished,
+		Shard:    r.Shard,
+		Batch:    r.Batch,
+	}
+	for _, p := range r.Projects {
+		if p.Repo == repo {
+			ret.Projects = append(ret.Projects, p)
+		}
+	}
+	for _, w := range r.WorkspaceChecks {
+		if w.Repo == repo {
+			ret.WorkspaceChecks = append(ret.WorkspaceChecks, w)
+		}
+	}
+	ret.Total = len(ret.Projects)
+	return ret
+}
+
+func (r *Report) Add(p Project) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Projects = append(r.Projects, p)
+}
+
+func (r *Report) AddWorkspaceCheck(w WorkspaceCheck) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.WorkspaceChecks = append(r.WorkspaceChecks, w)
+}
+
+func (r *Report) SetTotal(total int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Total = total
+}
+
+// AddTotal adds to the expected total, for runs that fill one report from several repos
+func (r *Report) AddTotal(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Total += n
+}
+
+// NameRepo sets the repo of a report created without one
+func (r *Report) NameRepo(repo string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Repo == "" {
+		r.Repo = repo
+	}
+}
+
+// SetShard labels the report with the share of projects the run checks
+func (r *Report) SetShard(shard string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Shard = shard
+}
+
+// SetBatch marks the report as covering only a batch of the projects
+func (r *Report) SetBatch() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Batch = true
+}
+
+// Progress returns how many projects have been checked so far, out of the expected total
+func (r *Report) Progress() (done int, total int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Projects), r.Total
+}
+
+func (r *Report) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Finished = time.Now()
+}
+
+// Count returns how many projects ended with the given outcome
+func (r *Report) Count(o Outcome) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, p := range r.Projects {
+		if p.Outcome == o {
+			count++
+		}
+	}
+	return count
+}
+
+// Counts returns how many projects ended with each outcome
+func (r *Report) Counts() map[Outcome]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := make(map[Outcome]int)
+	for _, p := range r.Projects {
+		ret[p.Outcome]++
+	}
+	return ret
+}
+
+// Failures returns every project that failed with an error, other than temporary errors.  This includes projects
+// that were checked but could not be notified about.
+func (r *Report) Failures() []Project {
+	var ret []Project
+	for _, p := range r.SortedProjects() {
+		if p.Error != "" && p.Outcome != OutcomeTemporaryError {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// HasDrift is true if any project drifted, including projects whose cached result is drift
+func (r *Report) HasDrift() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.Projects {
+		if p.Outcome == OutcomeDrifted || (p.Outcome == OutcomeSkippedCache && p.CachedDrift) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport_Count(t *testing.T) {
+	r := New("company/terraform")
+	var wg sync.WaitGroup
+	for _, o := range []Outcome{OutcomeClean, OutcomeDrifted, OutcomeClean, OutcomeLocked} {
+		wg.Add(1)
+		go func(o Outcome) {
+			defer wg.Done()
+			r.Add(Project{Dir: "dir", Workspace: "default", Outcome: o})
+		}(o)
+	}
+	wg.Wait()
+	r.Finish()
+	require.Equal(t, 2, r.Count(OutcomeClean))
+	require.Equal(t, 1, r.Count(OutcomeDrifted))
+	require.True(t, r.HasDrift())
+	require.False(t, r.Finished.Before(r.Started))
+}
+
+func TestReport_NoDrift(t *testing.T) {
+	r := New("company/terraform")
+	r.Add(Project{Dir: "dir", Workspace: "default", Outcome: OutcomeLocked})
+	r.Add(Project{Dir: "cached", Workspace: "default", Outcome: OutcomeSkippedCache})
+	require.False(t, r.HasDrift())
+}
+
+func TestReport_HasCachedDrift(t *testing.T) {
+	r := New("company/terraform")
+	r.Add(Project{Dir: "dir", Workspace: "default", Outcome: OutcomeSkippedCache, CachedDrift: true})
+	require.True(t, r.HasDrift())
+}
+
+func TestReport_WriteTable(t *testing.T) {
+	r := New("company/terraform")
+	r.Add(Project{Repo: "company/terraform", Dir: "infra/sandbox", Workspace: "default", Outcome: OutcomeSkippedFilter, Reason: "not in the directory whitelist"})
+	r.Add(Project{Repo: "company/terraform", Name: "prod", Dir: "infra/prod", Workspace: "default", Outcome: OutcomePlanned, Reason: "not in the cache"})
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteTable(&buf))
+	require.Equal(t, `REPO               PROJECT  DIR            WORKSPACE  OUTCOME         REASON
+company/terraform  prod     infra/prod     default    planned         not in the cache
+company/terraform           infra/sandbox  default    skipped_filter  not in the directory whitelist
+`, buf.String())
+}
+
+func TestReport_WriteFiles(t *testing.T) {
+	r := New("company/terraform")
+	start := time.Now()
+	firstDetected := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	r.Add(Project{Repo: "company/terraform", Name: "prod", Dir: "infra/prod", Workspace: "default", Outcome: OutcomeDrifted, Changes: &atlantis.PlanCounts{Add: 1, Change: 2}, FirstDetected: &firstDetected, LastDetected: &start, Detections: 3, Started: start, DurationSeconds: 1.5})
+	r.Add(Project{Repo: "company/terraform", Dir: "infra/shared", Workspace: "default", Outcome: OutcomeFailed, Error: "plan failed\nwith | pipes", Started: start})
+	r.Add(Project{Repo: "company/terraform", Name: "network", Dir: "infra/network", Workspace: "default", Outcome: OutcomeClean, Resolved: true, Started: start})
+	r.AddWorkspaceCheck(WorkspaceCheck{Repo: "company/terraform", Dir: "infra/shared", Outcome: WorkspaceOutcomeBackendAuth, Error: "backend authentication failed"})
+	r.AddWorkspaceCheck(WorkspaceCheck{Repo: "company/terraform", Dir: "infra/prod", Outcome: WorkspaceOutcomeExtra, Remote: []string{"default", "old"}, Extra: []string{"old"}})
+	r.AddWorkspaceCheck(WorkspaceCheck{Repo: "company/terraform", Dir: "infra/db", Outcome: WorkspaceOutcomeOK, Remote: []string{"default"}})
+	r.AddWorkspaceCheck(WorkspaceCheck{Repo: "company/terraform", Dir: "infra/staging", Outcome: WorkspaceOutcomeMissing, Remote: []string{"default"}, Missing: []string{"staging"}})
+	r.SetTotal(2)
+	r.SetShard("1-of-4")
+	r.Finish()
+	path := filepath.Join(t.TempDir(), "reports", "drift")
+	require.NoError(t, r.WriteFiles(path))
+
+	b, err := os.ReadFile(path + ".json")
+	require.NoError(t, err)
+	var decoded struct {
+		Shard           string           `json:"shard"`
+		Outcomes        map[Outcome]int  `json:"outcomes"`
+		Projects        []Project        `json:"projects"`
+		WorkspaceChecks []WorkspaceCheck `json:"workspace_checks"`
+	}
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	require.Equal(t, map[Outcome]int{OutcomeDrifted: 1, OutcomeFailed: 1, OutcomeClean: 1}, decoded.Outcomes)
+	require.True(t, decoded.Projects[0].Resolved)
+	require.Equal(t, &atlantis.PlanCounts{Add: 1, Change: 2}, decoded.Projects[1].Changes)
+	require.Equal(t, 3, decoded.Projects[1].Detections)
+	require.True(t, firstDetected.Equal(*decoded.Projects[1].FirstDetected))
+	require.Equal(t, "1-of-4", decoded.Shard)
+	require.Len(t, decoded.WorkspaceChecks, 4)
+	require.Equal(t, "infra/db", decoded.WorkspaceChecks[0].Dir)
+
+	b, err = os.ReadFile(path + ".md")
+	require.NoError(t, err)
+	require.Contains(t, string(b), "# Drift report: company/terraform (shard 1-of-4)\n")
+	require.Contains(t, string(b), "| company/terraform | prod | infra/prod | default | +1 ~2 -0 | 2024-01-01T09:00:00Z | 3 |")
+	require.Contains(t, string(b), "| failed | plan failed with \\| pipes |")
+	require.Contains(t, string(b), "## Resolved\n\n| Repo | Project | Dir | Workspace |\n|---|---|---|---|\n| company/terraform | network | infra/network | default |\n")
+	require.Contains(t, string(b), "| company/terraform | infra/prod | extra | old |  |  |")
+	require.Contains(t, string(b), "| company/terraform | infra/staging | missing |  | staging |  |")
+	require.Contains(t, string(b), "| company/terraform | infra/shared | backend_auth |  |  | backend authentication failed |")
+	require.NotContains(t, string(b), "| company/terraform | infra/db | ok |")
+}
+
+func TestReport_Failures(t *testing.T) {
+	r := New("company/terraform")
+	r.Add(Project{Dir: "infra/b", Workspace: "default", Outcome: OutcomeFailed, Error: "plan failed"})
+	r.Add(Project{Dir: "infra/a", Workspace: "default", Outcome: OutcomeDrifted, Error: "failed to notify"})
+	r.Add(Project{Dir: "infra/c", Workspace: "default", Outcome: OutcomeTemporaryError, Error: "503"})
+	r.Add(Project{Dir: "infra/d", Workspace: "default", Outcome: OutcomeClean})
+	failures := r.Failures()
+	require.Len(t, failures, 2)
+	require.Equal(t, "infra/a", failures[0].Dir)
+	require.Equal(t, "infra/b", failures[1].Dir)
+}
@@ -0,0 +1,190 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/drifter"
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// Group is a set of directories that are checked on their own cron schedules
+type Group struct {
+	Name        string
+	Schedules   []string
+	Directories []string
+}
+
+type Scheduler struct {
+	Logger   *zap.Logger
+	Runner   drifter.Runner
+	Location *time.Location
+	// Schedules are cron expressions that check every directory
+	Schedules []string
+	// Groups are checked on their own schedules, limited to their directories
+	Groups []Group
+	// TrickleInterval, if set, replaces Schedules: every interval, the TrickleBatch projects of each repo most due for
+	// a check are checked, keeping the load on Atlantis flat instead of planning everything at once
+	TrickleInterval time.Duration
+	TrickleBatch    int
+
+	cron        *cron.Cron
+	stopTrickle chan struct{}
+	trickleDone chan struct{}
+}
+
+// ParseGroups parses group schedules in the form "<cron>=<dir>[,<dir>...]", separated by ";".
+// For example: "0 * * * *=infra/prod,infra/shared;@weekly=infra/sandbox"
+func ParseGroups(s string) ([]Group, error) {
+	var ret []Group
+	for _, entry := range SplitSchedules(s) {
+		schedule, dirs, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid group schedule %q: expected <cron>=<dir>[,<dir>...]", entry)
+		}
+		g := Group{
+			Name:      fmt.Sprintf("group-%d", len(ret)+1),
+			Schedules: []string{strings.TrimSpace(schedule)},
+		}
+		for _, dir := range strings.Split(dirs, ",") {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				g.Directories = append(g.Directories, dir)
+			}
+		}
+		if len(g.Directories) == 0 {
+			return nil, fmt.Errorf("invalid group schedule %q: no directories", entry)
+		}
+		ret = append(ret, g)
+	}
+	return ret, nil
+}
+
+// SplitSchedules splits a ";" separated list of cron expressions.  Commas are not used as a separator because they
+// are valid inside a cron expression.
+func SplitSchedules(s string) []string {
+	var ret []string
+	for _, part := range strings.Split(s, ";") {
+		if part = strings.TrimSpace(part); part != "" {
+			ret = append(ret, part)
+		}
+	}
+	return ret
+}
+
+func (s *Scheduler) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
+}
+
+// Start validates every schedule and starts running them in the background
+func (s *Scheduler) Start(ctx context.Context) error {
+	c := cron.New(cron.WithLocation(s.location()), cron.WithChain(cron.SkipIfStillRunning(&cronLogger{logger: s.Logger})))
+	for _, schedule := range s.Schedules {
+		if s.TrickleInterval > 0 {
+			s.Logger.Info("Ignoring schedule in trickle mode", zap.String("schedule", schedule))
+			continue
+		}
+		if _, err := c.AddFunc(schedule, s.runFunc(ctx, "all", drifter.RunOptions{})); err != nil {
+			return fmt.Errorf("invalid schedule %q: %w", schedule, err)
+		}
+		s.Logger.Info("Scheduled drift detection", zap.String("schedule", schedule), zap.String("timezone", s.location().String()))
+	}
+	for _, g := range s.Groups {
+		for _, schedule := range g.Schedules {
+			if _, err := c.AddFunc(schedule, s.runFunc(ctx, g.Name, drifter.RunOptions{Directories: g.Directories})); err != nil {
+				return fmt.Errorf("invalid schedule %q for group %s: %w", schedule, g.Name, err)
+			}
+			s.Logger.Info("Scheduled drift detection for group", zap.String("group", g.Name), zap.String("schedule", schedule), zap.Strings("directories", g.Directories), zap.String("timezone", s.location().String()))
+		}
+	}
+	s.cron = c
+	c.Start()
+	if s.TrickleInterval > 0 {
+		s.startTrickle(ctx)
+	}
+	return nil
+}
+
+// startTrickle checks a batch of projects every TrickleInterval.  A batch that takes longer than the interval delays
+// the next one rather than overlapping it.
+func (s *Scheduler) startTrickle(ctx context.Context) {
+	s.stopTrickle = make(chan struct{})
+	s.trickleDone = make(chan struct{})
+	run := s.runFunc(ctx, "trickle", drifter.RunOptions{Limit: s.TrickleBatch})
+	stop, done := s.stopTrickle, s.trickleDone
+	s.Logger.Info("Scheduled trickle drift detection", zap.Duration("interval", s.TrickleInterval), zap.Int("batch", s.TrickleBatch))
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.TrickleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			run()
+		}
+	}()
+}
+
+// Stop stops scheduling new runs.  The returned context is done once running jobs have completed.
+func (s *Scheduler) Stop() context.Context {
+	var running []<-chan struct{}
+	if s.cron != nil {
+		running = append(running, s.cron.Stop().Done())
+	}
+	if s.stopTrickle != nil {
+		close(s.stopTrickle)
+		s.stopTrickle = nil
+		running = append(running, s.trickleDone)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer cancel()
+		for _, done := range running {
+			<-done
+		}
+	}()
+	return ctx
+}
+
+func (s *Scheduler) runFunc(ctx context.Context, name string, opts drifter.RunOptions) func() {
+	return func() {
+		logger := s.Logger.With(zap.String("group", name))
+		logger.Info("Running scheduled drift detection")
+		rep, err := s.Runner.Run(ctx, opts)
+		if errors.Is(err, drifter.ErrRunLocked) {
+			logger.Info("Skipping scheduled drift detection, another instance is running it")
+			return
+		}
+		if err != nil {
+			logger.Error("Drift detection failed", zap.Error(err))
+			return
+		}
+		logger.Info("Drift detection completed successfully", zap.Int("drifted", rep.Count(report.OutcomeDrifted)))
+	}
+}
+
+type cronLogger struct {
+	logger *zap.Logger
+}
+
+func (c *cronLogger) Info(msg string, keysAndValues ...interface{}) {
+	c.logger.Sugar().Infow(msg, keysAndValues...)
+}
+
+func (c *cronLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	c.logger.Sugar().Errorw(msg, append(keysAndValues, "error", err)...)
+}
+
+var _ cron.Logger = (*cronLogger)(nil)
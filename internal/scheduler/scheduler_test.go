@@ -0,0 +1,85 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/drifter"
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type noopRunner struct{}
+
+func (n noopRunner) Run(_ context.Context, _ drifter.RunOptions) (*report.Report, error) {
+	return report.New(""), nil
+}
+
+func TestSplitSchedules(t *testing.T) {
+	require.Equal(t, []string{"0 9 * * *", "0 9,17 * * 1-5"}, SplitSchedules("0 9 * * *; 0 9,17 * * 1-5;"))
+	require.Empty(t, SplitSchedules(""))
+}
+
+func TestParseGroups(t *testing.T) {
+	groups, err := ParseGroups("0 * * * *=infra/prod, infra/shared;@weekly=infra/sandbox")
+	require.NoError(t, err)
+	require.Equal(t, []Group{
+		{Name: "group-1", Schedules: []string{"0 * * * *"}, Directories: []string{"infra/prod", "infra/shared"}},
+		{Name: "group-2", Schedules: []string{"@weekly"}, Directories: []string{"infra/sandbox"}},
+	}, groups)
+
+	_, err = ParseGroups("0 * * * *")
+	require.Error(t, err)
+	_, err = ParseGroups("0 * * * *=")
+	require.Error(t, err)
+}
+
+func TestScheduler_StartInvalidSchedule(t *testing.T) {
+	s := Scheduler{
+		Logger:    zaptest.NewLogger(t),
+		Runner:    noopRunner{},
+		Schedules: []string{"not a cron"},
+	}
+	require.Error(t, s.Start(context.Background()))
+}
+
+func TestScheduler_Start(t *testing.T) {
+	s := Scheduler{
+		Logger:    zaptest.NewLogger(t),
+		Runner:    noopRunner{},
+		Schedules: []string{"0 9 * * *"},
+		Groups:    []Group{{Name: "hourly", Schedules: []string{"@hourly"}, Directories: []string{"infra"}}},
+	}
+	require.NoError(t, s.Start(context.Background()))
+	<-s.Stop().Done()
+}
+
+type countingRunner struct {
+	runs chan drifter.RunOptions
+}
+
+func (c countingRunner) Run(_ context.Context, opts drifter.RunOptions) (*report.Report, error) {
+	select {
+	case c.runs <- opts:
+	default:
+	}
+	return report.New(""), nil
+}
+
+func TestScheduler_Trickle(t *testing.T) {
+	runner := countingRunner{runs: make(chan drifter.RunOptions, 10)}
+	s := Scheduler{
+		Logger:          zaptest.NewLogger(t),
+		Runner:          runner,
+		Schedules:       []string{"* * * * *"},
+		TrickleInterval: 10 * time.Millisecond,
+		TrickleBatch:    5,
+	}
+	require.NoError(t, s.Start(context.Background()))
+	for i := 0; i < 2; i++ {
+		require.Equal(t, drifter.RunOptions{Limit: 5}, <-runner.runs)
+	}
+	<-s.Stop().Done()
+}
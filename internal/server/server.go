@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/drifter"
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+	"go.uber.org/zap"
+)
+
+// maxRunHistory is how many finished runs are remembered for GET /runs/{id}
+const maxRunHistory = 100
+
+// shutdownTimeout bounds how long open connections get to finish once the server is stopping
+const shutdownTimeout = 10 * time.Second
+
+// ReadinessCheck is one dependency verified by /readyz
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Server exposes health, readiness and on-demand drift runs over HTTP
+type Server struct {
+	Logger          *zap.Logger
+	Runner          drifter.Runner
+	ReadinessChecks []ReadinessCheck
+	// Token must be sent as a bearer token to trigger runs.  If empty, runs cannot be triggered.
+	Token string
+	// Metrics, if set, is served on /metrics
+	Metrics http.Handler
+
+	running sync.WaitGroup
+	mu      sync.Mutex
+	runs    map[string]*run
+	order   []string
+	nextID  int
+	// active is the triggered run in progress, if any
+	active *run
+}
+
+type RunStatus string
+
+const (
+	RunStatusRunning   RunStatus = "running"
+	RunStatusSucceeded RunStatus = "succeeded"
+	RunStatusFailed    RunStatus = "failed"
+	// RunStatusSkipped means another instance held the run lease
+	RunStatusSkipped RunStatus = "skipped"
+)
+
+type run struct {
+	id          string
+	directories []string
+	started     time.Time
+	report      *report.Report
+
+	// Guarded by Server.mu
+	status   RunStatus
+	finished time.Time
+	err      error
+}
+
+// RunResponse is the JSON body returned for a run
+type RunResponse struct {
+	ID          string                 `json:"id"`
+	Status      RunStatus              `json:"status"`
+	Directories []string               `json:"directories,omitempty"`
+	Started     time.Time              `json:"started"`
+	Finished    *time.Time             `json:"finished,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	Done        int                    `json:"done"`
+	Total       int                    `json:"total"`
+	Outcomes    map[report.Outcome]int `json:"outcomes"`
+}
+
+// RunRequest is the optional JSON body of POST /runs
+type RunRequest struct {
+	Directories []string `json:"directories"`
+}
+
+// Handler returns the HTTP handler.  Triggered runs use ctx, so they outlive the request that started them.
+func (s *Server) Handler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	// Anyone who can reach the server could start runs without a token, so the endpoints only exist with one
+	if s.Token != "" {
+		mux.HandleFunc("POST /runs", func(w http.ResponseWriter, r *http.Request) {
+			s.startRun(ctx, w, r)
+		})
+		mux.HandleFunc("GET /runs/{id}", func(w http.ResponseWriter, r *http.Request) {
+			if !s.authorized(w, r) {
+				return
+			}
+			s.getRun(w, r)
+		})
+	}
+	if s.Metrics != nil {
+		mux.Handle("GET /metrics", s.Metrics)
+	}
+	return mux
+}
+
+// ListenAndServe serves on addr until ctx is done.  It then shuts down gracefully and returns once every triggered
+// run, which is cancelled along with ctx, has finished.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(ctx),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		s.Logger.Info("Starting http server", zap.String("addr", addr))
+		serveErr <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("http server failed: %w", err)
+	case <-ctx.Done():
+	}
+	s.Logger.Info("Stopping http server")
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		s.Logger.Warn("failed to shut down http server cleanly", zap.Error(err))
+	}
+	s.running.Wait()
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("http server failed: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) healthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	status := http.StatusOK
+	results := make(map[string]string, len(s.ReadinessChecks))
+	for _, c := range s.ReadinessChecks {
+		if err := c.Check(ctx); err != nil {
+			s.Logger.Warn("readiness check failed", zap.String("check", c.Name), zap.Error(err))
+			results[c.Name] = err.Error()
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		results[c.Name] = "ok"
+	}
+	writeJSON(w, status, results)
+}
+
+// authorized checks the bearer token, answering 401 if it is wrong
+func (s *Server) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.Token)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return false
+	}
+	return true
+}
+
+// startRun starts a run in the background.  Only one triggered run runs at a time; while it does, startRun answers
+// 409 with its id.
+func (s *Server) startRun(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	var req RunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid request body: %s", err)})
+		return
+	}
+	rn, active := s.newRun(req.Directories)
+	if active != nil {
+		w.Header().Set("Location", "/runs/"+active.id)
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "a run is already in progress", "id": active.id})
+		return
+	}
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		logger := s.Logger.With(zap.String("run", rn.id))
+		logger.Info("Running triggered drift detection", zap.Strings("directories", rn.directories))
+		_, err := s.Runner.Run(ctx, drifter.RunOptions{
+			Directories: rn.directories,
+			Report:      rn.report,
+		})
+		if err != nil {
+			logger.Error("Triggered drift detection failed", zap.Error(err))
+		} else {
+			logger.Info("Triggered drift detection completed successfully")
+		}
+		s.finishRun(rn, err)
+	}()
+	w.Header().Set("Location", "/runs/"+rn.id)
+	writeJSON(w, http.StatusAccepted, s.runResponse(rn))
+}
+
+func (s *Server) getRun(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	rn, ok := s.runs[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "run not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.runResponse(rn))
+}
+
+// newRun records a new run, unless one is already in progress, in which case it returns that one as active
+func (s *Server) newRun(dirs []string) (rn *run, active *run) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != nil {
+		return nil, s.active
+	}
+	if s.runs == nil {
+		s.runs = make(map[string]*run)
+	}
+	s.nextID++
+	rn = &run{
+		id:          strconv.Itoa(s.nextID),
+		directories: dirs,
+		started:     time.Now(),
+		// The runner names the repos it checks
+		report: report.New(""),
+		status: RunStatusRunning,
+	}
+	s.active = rn
+	s.runs[rn.id] = rn
+	s.order = append(s.order, rn.id)
+	if len(s.order) > maxRunHistory {
+		delete(s.runs, s.order[0])
+		s.order = s.order[1:]
+	}
+	return rn, nil
+}
+
+func (s *Server) finishRun(rn *run, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == rn {
+		s.active = nil
+	}
+	rn.finished = time.Now()
+	rn.err = err
+	switch {
+	case errors.Is(err, drifter.ErrRunLocked):
+		rn.status = RunStatusSkipped
+	case err != nil:
+		rn.status = RunStatusFailed
+	default:
+		rn.status = RunStatusSucceeded
+	}
+}
+
+func (s *Server) runResponse(rn *run) RunResponse {
+	done, total := rn.report.Progress()
+	outcomes := rn.report.Counts()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := RunResponse{
+		ID:          rn.id,
+		Status:      rn.status,
+		Directories: rn.directories,
+		Started:     rn.started,
+		Done:        done,
+		Total:       total,
+		Outcomes:    outcomes,
+	}
+	if !rn.finished.IsZero() {
+		finished := rn.finished
+		ret.Finished = &finished
+	}
+	if rn.err != nil {
+		ret.Error = rn.err.Error()
+	}
+	return ret
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/drifter"
+	"github.com/cresta/atlantis-drift-detection/internal/report"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type fakeRunner struct {
+	opts chan drifter.RunOptions
+}
+
+func (f *fakeRunner) Run(_ context.Context, opts drifter.RunOptions) (*report.Report, error) {
+	opts.Report.SetTotal(2)
+	opts.Report.Add(report.Project{Dir: "a", Workspace: "default", Outcome: report.OutcomeClean})
+	opts.Report.Add(report.Project{Dir: "b", Workspace: "default", Outcome: report.OutcomeDrifted})
+	f.opts <- opts
+	return opts.Report, nil
+}
+
+func TestServer_Healthz(t *testing.T) {
+	s := Server{Logger: zaptest.NewLogger(t)}
+	rec := httptest.NewRecorder()
+	s.Handler(context.Background()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestServer_Readyz(t *testing.T) {
+	s := Server{
+		Logger: zaptest.NewLogger(t),
+		ReadinessChecks: []ReadinessCheck{
+			{Name: "cache", Check: func(_ context.Context) error { return nil }},
+			{Name: "atlantis", Check: func(_ context.Context) error { return errors.New("unreachable") }},
+		},
+	}
+	rec := httptest.NewRecorder()
+	s.Handler(context.Background()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var body map[string]string
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	require.Equal(t, map[string]string{"cache": "ok", "atlantis": "unreachable"}, body)
+}
+
+func TestServer_Runs(t *testing.T) {
+	runner := &fakeRunner{opts: make(chan drifter.RunOptions, 1)}
+	s := Server{Logger: zaptest.NewLogger(t), Runner: runner, Token: "secret"}
+	h := s.Handler(context.Background())
+
+	req := httptest.NewRequest(http.MethodPost, "/runs", strings.NewReader(`{"directories": ["infra/prod"]}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.Equal(t, "/runs/1", rec.Header().Get("Location"))
+	opts := <-runner.opts
+	require.Equal(t, []string{"infra/prod"}, opts.Directories)
+
+	require.Eventually(t, func() bool {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, authorizedRequest(http.MethodGet, "/runs/1"))
+		var resp RunResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		return resp.Status == RunStatusSucceeded && resp.Done == 2 && resp.Total == 2 && resp.Outcomes[report.OutcomeDrifted] == 1
+	}, time.Second, 10*time.Millisecond)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, authorizedRequest(http.MethodGet, "/runs/2"))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Run details include directories and errors, so reading them needs the token too
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/runs/1", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func authorizedRequest(method string, target string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	return req
+}
+
+// blockingRunner runs until release is closed
+type blockingRunner struct {
+	release chan struct{}
+}
+
+func (b *blockingRunner) Run(_ context.Context, opts drifter.RunOptions) (*report.Report, error) {
+	<-b.release
+	return opts.Report, nil
+}
+
+func TestServer_RunsOneAtATime(t *testing.T) {
+	runner := &blockingRunner{release: make(chan struct{})}
+	s := Server{Logger: zaptest.NewLogger(t), Runner: runner, Token: "secret"}
+	h := s.Handler(context.Background())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, authorizedRequest(http.MethodPost, "/runs"))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, authorizedRequest(http.MethodPost, "/runs"))
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Equal(t, "/runs/1", rec.Header().Get("Location"))
+	var body map[string]string
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	require.Equal(t, "1", body["id"])
+
+	close(runner.release)
+	require.Eventually(t, func() bool {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, authorizedRequest(http.MethodPost, "/runs"))
+		return rec.Code == http.StatusAccepted
+	}, time.Second, 10*time.Millisecond)
+	s.running.Wait()
+}
+
+func TestServer_RunsRequiresToken(t *testing.T) {
+	s := Server{Logger: zaptest.NewLogger(t), Runner: &fakeRunner{opts: make(chan drifter.RunOptions, 1)}, Token: "secret"}
+	h := s.Handler(context.Background())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/runs", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/runs", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+}
+
+func TestServer_RunsDisabledWithoutToken(t *testing.T) {
+	s := Server{Logger: zaptest.NewLogger(t), Runner: &fakeRunner{opts: make(chan drifter.RunOptions, 1)}}
+	h := s.Handler(context.Background())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/runs", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestServer_ListenAndServeStopsWithContext(t *testing.T) {
+	s := Server{Logger: zaptest.NewLogger(t)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ListenAndServe(ctx, "127.0.0.1:0")
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+}
@@ -4,15 +4,20 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"github.com/cresta/pipe"
-	"go.uber.org/zap"
+	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/cresta/pipe"
+	"go.uber.org/zap"
 )
 
+// Client runs terraform.  Every call names the directory it runs in, so one client can be shared by checkouts.
 type Client struct {
-	Directory string
-	Logger    *zap.Logger
+	Logger *zap.Logger
+	// PluginMirrorDir, if set, is a read-only provider mirror shared by every init, for example one filled by
+	// "terraform providers mirror".  Terraform never writes to it, so parallel inits can share it safely.
+	PluginMirrorDir string
 }
 
 type execErr struct {
@@ -29,30 +34,100 @@ func (e *execErr) Error() string {
 	return fmt.Sprintf("%s:%s:%s", e.stdout.String(), e.stderr.String(), e.root.Error())
 }
 
-func (c *Client) Init(ctx context.Context, subDir string) error {
-	c.Logger.Info("Initializing terraform", zap.String("dir", subDir))
-	var stdout, stderr bytes.Buffer
-	result := pipe.NewPiped("terraform", "init", "-no-color").WithDir(filepath.Join(c.Directory, subDir)).Execute(ctx, nil, &stdout, &stderr)
-	if result != nil {
-		return &execErr{
-			stdout: stdout,
-			stderr: stderr,
-			root:   result,
+// BackendAuthError means terraform could not authenticate to the state backend.  This is usually a missing permission
+// for whoever runs drift detection, rather than a problem with the project itself.
+type BackendAuthError struct {
+	err error
+}
+
+func (e *BackendAuthError) Error() string {
+	return fmt.Sprintf("backend authentication failed: %s", e.err)
+}
+
+func (e *BackendAuthError) Unwrap() error {
+	return e.err
+}
+
+// backendAuthMessages appear in terraform's output when the S3, GCS or azurerm backends are denied or have no
+// credentials
+var backendAuthMessages = []string{
+	"NoCredentialProviders",
+	"no valid credential sources",
+	"ExpiredToken",
+	"InvalidClientTokenId",
+	"SignatureDoesNotMatch",
+	"AccessDenied",
+	"StatusCode: 403",
+	"status code: 403",
+	"could not find default credentials",
+	"AuthorizationFailed",
+	"AuthorizationPermissionMismatch",
+}
+
+// classify wraps a failed command's error in BackendAuthError if its output says the backend rejected credentials
+func classify(e *execErr) error {
+	out := e.stderr.String() + e.stdout.String()
+	for _, msg := range backendAuthMessages {
+		if strings.Contains(out, msg) {
+			return &BackendAuthError{err: e}
 		}
 	}
-	return nil
+	return e
 }
 
-func (c *Client) ListWorkspaces(ctx context.Context, subDir string) ([]string, error) {
-	c.Logger.Info("Listing workspaces", zap.String("dir", subDir))
+// command runs terraform in dir.  dataDir, if set, is used as TF_DATA_DIR so that commands for different projects
+// never share a .terraform directory.
+func (c *Client) command(ctx context.Context, dir string, dataDir string, args ...string) (*bytes.Buffer, error) {
+	env := append(os.Environ(), "TF_IN_AUTOMATION=1", "TF_INPUT=0")
+	if dataDir != "" {
+		if err := os.MkdirAll(dataDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create terraform data dir: %w", err)
+		}
+		env = append(env, "TF_DATA_DIR="+dataDir)
+		if c.PluginMirrorDir != "" {
+			cliConfig := filepath.Join(dataDir, "terraformrc")
+			if err := os.WriteFile(cliConfig, []byte(mirrorCLIConfig(c.PluginMirrorDir)), 0o644); err != nil {
+				return nil, fmt.Errorf("failed to write terraform cli config: %w", err)
+			}
+			env = append(env, "TF_CLI_CONFIG_FILE="+cliConfig)
+		}
+	}
 	var stdout, stderr bytes.Buffer
-	result := pipe.NewPiped("terraform", "workspace", "list").WithDir(filepath.Join(c.Directory, subDir)).Execute(ctx, nil, &stdout, &stderr)
+	result := pipe.NewPiped("terraform", args...).WithEnv(env).WithDir(dir).Execute(ctx, nil, &stdout, &stderr)
 	if result != nil {
-		return nil, &execErr{
+		return nil, classify(&execErr{
 			stdout: stdout,
 			stderr: stderr,
 			root:   result,
-		}
+		})
+	}
+	return &stdout, nil
+}
+
+// mirrorCLIConfig installs providers from the mirror when it has them, and downloads the rest
+func mirrorCLIConfig(mirror string) string {
+	return fmt.Sprintf(`provider_installation {
+  filesystem_mirror {
+    path = %q
+  }
+  direct {}
+}
+`, mirror)
+}
+
+// Init runs terraform init in dir, using dataDir as TF_DATA_DIR if it is set
+func (c *Client) Init(ctx context.Context, dir string, dataDir string) error {
+	c.Logger.Info("Initializing terraform", zap.String("dir", dir))
+	_, err := c.command(ctx, dir, dataDir, "init", "-no-color", "-input=false")
+	return err
+}
+
+// ListWorkspaces lists the workspaces in the backend of dir, which must have been initialized with the same dataDir
+func (c *Client) ListWorkspaces(ctx context.Context, dir string, dataDir string) ([]string, error) {
+	c.Logger.Info("Listing workspaces", zap.String("dir", dir))
+	stdout, err := c.command(ctx, dir, dataDir, "workspace", "list")
+	if err != nil {
+		return nil, err
 	}
 	lines := strings.Split(stdout.String(), "\n")
 	workspaces := make([]string, 0, len(lines))
@@ -1,11 +1,14 @@
 package terraform
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"github.com/cresta/atlantis-drift-detection/internal/testhelper"
 	"github.com/cresta/pipe"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -13,36 +16,69 @@ import (
 func TestClient_Init(t *testing.T) {
 	testhelper.ReadEnvFile(t, "../../")
 	c := Client{
-		Directory: testhelper.EnvOrSkip(t, "TERRAFORM_DIR"),
-		Logger:    zaptest.NewLogger(t),
+		Logger: zaptest.NewLogger(t),
 	}
-	require.NoError(t, c.Init(context.Background(), testhelper.EnvOrSkip(t, "TERRAFORM_SUBDIR")))
+	dir := filepath.Join(testhelper.EnvOrSkip(t, "TERRAFORM_DIR"), testhelper.EnvOrSkip(t, "TERRAFORM_SUBDIR"))
+	require.NoError(t, c.Init(context.Background(), dir, ""))
 }
 
 func TestClient_InitEmptydir(t *testing.T) {
 	td := t.TempDir()
 	c := Client{
-		Directory: td,
-		Logger:    zaptest.NewLogger(t),
+		Logger: zaptest.NewLogger(t),
 	}
-	require.NoError(t, c.Init(context.Background(), ""))
+	require.NoError(t, c.Init(context.Background(), td, ""))
 }
 
 func TestClient_ListWorkspaces(t *testing.T) {
 	testhelper.ReadEnvFile(t, "../../")
 	td := t.TempDir()
 	c := Client{
-		Directory: td,
-		Logger:    zaptest.NewLogger(t),
+		Logger: zaptest.NewLogger(t),
 	}
-	const subdir = ""
-	require.NoError(t, c.Init(context.Background(), subdir))
-	workspaces, err := c.ListWorkspaces(context.Background(), subdir)
+	require.NoError(t, c.Init(context.Background(), td, ""))
+	workspaces, err := c.ListWorkspaces(context.Background(), td, "")
 	require.NoError(t, err)
 	require.Equal(t, []string{"default"}, workspaces)
 	ctx := context.Background()
-	require.NoError(t, pipe.NewPiped("terraform", "workspace", "new", "testing").WithDir(filepath.Join(c.Directory)).Run(ctx))
-	workspaces, err = c.ListWorkspaces(context.Background(), subdir)
+	require.NoError(t, pipe.NewPiped("terraform", "workspace", "new", "testing").WithDir(td).Run(ctx))
+	workspaces, err = c.ListWorkspaces(context.Background(), td, "")
 	require.NoError(t, err)
 	require.Equal(t, []string{"default", "testing"}, workspaces)
 }
+
+func TestClient_InitDataDir(t *testing.T) {
+	testhelper.BinaryOrSkip(t, "terraform")
+	td := t.TempDir()
+	dataDir := filepath.Join(t.TempDir(), "data")
+	c := Client{
+		Logger:          zaptest.NewLogger(t),
+		PluginMirrorDir: t.TempDir(),
+	}
+	require.NoError(t, c.Init(context.Background(), td, dataDir))
+	workspaces, err := c.ListWorkspaces(context.Background(), td, dataDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"default"}, workspaces)
+	_, err = os.Stat(filepath.Join(td, ".terraform"))
+	require.True(t, os.IsNotExist(err), "init should not write to the project directory")
+}
+
+func TestClassify(t *testing.T) {
+	authErr := &execErr{root: errors.New("exit status 1")}
+	authErr.stderr.WriteString("Error: error configuring S3 Backend: no valid credential sources for S3 Backend found.")
+	var backendAuth *BackendAuthError
+	require.ErrorAs(t, classify(authErr), &backendAuth)
+
+	otherErr := &execErr{root: errors.New("exit status 1"), stderr: *bytes.NewBufferString("Error: Unsupported argument")}
+	require.False(t, errors.As(classify(otherErr), &backendAuth))
+}
+
+func TestMirrorCLIConfig(t *testing.T) {
+	require.Equal(t, `provider_installation {
+  filesystem_mirror {
+    path = "/opt/providers"
+  }
+  direct {}
+}
+`, mirrorCLIConfig("/opt/providers"))
+}
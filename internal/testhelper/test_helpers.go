@@ -4,6 +4,7 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/require"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
 )
@@ -30,3 +31,10 @@ func EnvOrSkip(t *testing.T, env string) string {
 	}
 	return body
 }
+
+// BinaryOrSkip skips the test if name is not on PATH
+func BinaryOrSkip(t *testing.T, name string) {
+	if _, err := exec.LookPath(name); err != nil {
+		t.Skip(name + " not on PATH, skipping test")
+	}
+}